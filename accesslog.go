@@ -0,0 +1,163 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxPendingOps bounds the requests waiting for their RESULT line, so
+// operations that never get one (abandoned, or lost to rotation) cannot
+// grow the table without limit.
+const maxPendingOps = 100000
+
+// accessLogLineRe matches the operation lines of the access log, e.g.
+//
+//	[31/Jan/2024:12:00:00.123456789 +0000] conn=12 op=3 SRCH base="dc=example,dc=com" scope=2 ...
+//	[31/Jan/2024:12:00:00.124000000 +0000] conn=12 op=3 RESULT err=0 tag=101 nentries=1 wtime=0.000123 optime=0.000456 etime=0.000579
+var accessLogLineRe = regexp.MustCompile(`^\[[^\]]*\] conn=(\d+) op=(-?\d+) (\S+)(.*)$`)
+
+// accessLogOps maps the request keywords of the access log to op label
+// values. Other keywords (SORT, VLV, ENTRY, ...) annotate a request and are
+// ignored.
+var accessLogOps = map[string]string{
+	"BIND":   "bind",
+	"SRCH":   "search",
+	"ADD":    "add",
+	"MOD":    "modify",
+	"DEL":    "delete",
+	"MODRDN": "modrdn",
+	"CMP":    "compare",
+	"EXT":    "extended",
+}
+
+// accessLogBuckets span 100µs to about 26s.
+var accessLogBuckets = prometheus.ExponentialBuckets(0.0001, 4, 10)
+
+type connOp struct {
+	conn, op uint64
+}
+
+// accessLogCollector turns the access log into latency histograms. Request
+// lines are remembered by conn and op until the RESULT line with the
+// timings arrives.
+type accessLogCollector struct {
+	mu      sync.Mutex
+	pending map[connOp]string
+
+	etime     *prometheus.HistogramVec
+	wtime     *prometheus.HistogramVec
+	optime    *prometheus.HistogramVec
+	unmatched prometheus.Counter
+}
+
+func newAccessLogCollector() *accessLogCollector {
+	histogram := func(name, help string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      help,
+			Buckets:   accessLogBuckets,
+		}, []string{"op", "result"})
+	}
+	return &accessLogCollector{
+		pending: make(map[connOp]string),
+		etime:   histogram("access_etime_seconds", "Elapsed time of operations from the access log, from receipt to result"),
+		wtime:   histogram("access_wtime_seconds", "Time operations from the access log waited in the work queue for a worker thread"),
+		optime:  histogram("access_optime_seconds", "Time worker threads spent processing operations from the access log"),
+		unmatched: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "access_unmatched_results_total",
+			Help:      "RESULT lines of the access log whose request line was not seen",
+		}),
+	}
+}
+
+func (c *accessLogCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.etime.Describe(ch)
+	c.wtime.Describe(ch)
+	c.optime.Describe(ch)
+	c.unmatched.Describe(ch)
+}
+
+func (c *accessLogCollector) Collect(ch chan<- prometheus.Metric) {
+	c.etime.Collect(ch)
+	c.wtime.Collect(ch)
+	c.optime.Collect(ch)
+	c.unmatched.Collect(ch)
+}
+
+// handleLine processes one access log line.
+func (c *accessLogCollector) handleLine(line string) {
+	m := accessLogLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	conn, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return
+	}
+	op, opErr := strconv.ParseUint(m[2], 10, 64)
+	keyword := m[3]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if opErr != nil {
+		// op=-1 marks connection events; a closed connection completes
+		// nothing more.
+		if strings.HasPrefix(keyword, "fd=") && strings.HasPrefix(strings.TrimSpace(m[4]), "closed") {
+			for k := range c.pending {
+				if k.conn == conn {
+					delete(c.pending, k)
+				}
+			}
+		}
+		return
+	}
+	key := connOp{conn: conn, op: op}
+
+	if opName, ok := accessLogOps[keyword]; ok {
+		if len(c.pending) >= maxPendingOps {
+			c.pending = make(map[connOp]string)
+		}
+		c.pending[key] = opName
+		return
+	}
+	if keyword != "RESULT" {
+		return
+	}
+
+	opName, ok := c.pending[key]
+	if !ok {
+		c.unmatched.Inc()
+		return
+	}
+	delete(c.pending, key)
+
+	fields := keyValues(m[4])
+	result := fields["err"]
+	if v, err := strconv.ParseFloat(fields["etime"], 64); err == nil {
+		c.etime.WithLabelValues(opName, result).Observe(v)
+	}
+	if v, err := strconv.ParseFloat(fields["wtime"], 64); err == nil {
+		c.wtime.WithLabelValues(opName, result).Observe(v)
+	}
+	if v, err := strconv.ParseFloat(fields["optime"], 64); err == nil {
+		c.optime.WithLabelValues(opName, result).Observe(v)
+	}
+}
+
+// keyValues splits the unquoted key=value fields of a log line.
+func keyValues(s string) map[string]string {
+	kv := make(map[string]string)
+	for _, f := range strings.Fields(s) {
+		if k, v, ok := strings.Cut(f, "="); ok {
+			kv[k] = v
+		}
+	}
+	return kv
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAccessLogCollector(t *testing.T) {
+	c := newAccessLogCollector()
+	for _, line := range []string{
+		`[31/Jan/2024:12:00:00.100000000 +0000] conn=12 fd=64 slot=64 connection from 10.0.0.1 to 10.0.0.2`,
+		`[31/Jan/2024:12:00:00.110000000 +0000] conn=12 op=0 BIND dn="cn=app,dc=example,dc=com" method=128 version=3`,
+		`[31/Jan/2024:12:00:00.120000000 +0000] conn=12 op=0 RESULT err=0 tag=97 nentries=0 wtime=0.000100 optime=0.000200 etime=0.000300 dn="cn=app,dc=example,dc=com"`,
+		`[31/Jan/2024:12:00:00.130000000 +0000] conn=12 op=1 SRCH base="dc=example,dc=com" scope=2 filter="(uid=a)" attrs=ALL`,
+		`[31/Jan/2024:12:00:00.130000000 +0000] conn=12 op=1 SORT cn`,
+		`[31/Jan/2024:12:00:00.140000000 +0000] conn=12 op=2 SRCH base="dc=example,dc=com" scope=2 filter="(uid=b)" attrs=ALL`,
+		`[31/Jan/2024:12:00:00.150000000 +0000] conn=12 op=2 RESULT err=32 tag=101 nentries=0 wtime=0.5 optime=0.25 etime=0.75`,
+		`[31/Jan/2024:12:00:00.160000000 +0000] conn=12 op=1 RESULT err=0 tag=101 nentries=1 wtime=0.001 optime=0.002 etime=0.003`,
+		`[31/Jan/2024:12:00:00.170000000 +0000] conn=13 op=5 RESULT err=0 tag=101 nentries=1 etime=0.001`,
+		`[31/Jan/2024:12:00:00.180000000 +0000] conn=12 op=3 MOD dn="uid=a,dc=example,dc=com"`,
+		`[31/Jan/2024:12:00:00.190000000 +0000] conn=12 op=-1 fd=64 closed - U1`,
+		`[31/Jan/2024:12:00:00.200000000 +0000] conn=12 op=3 RESULT err=0 tag=103 nentries=0 etime=0.001`,
+		`[31/Jan/2024:12:00:00.200000000 +0000] conn=Internal(0) op=0(0)(0) SRCH base="cn=config"`,
+		`not a log line`,
+	} {
+		c.handleLine(line)
+	}
+
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		wantCount uint64
+		wantSum   float64
+	}{
+		{"ds_exporter_access_etime_seconds", map[string]string{"op": "bind", "result": "0"}, 1, 0.0003},
+		{"ds_exporter_access_wtime_seconds", map[string]string{"op": "search", "result": "32"}, 1, 0.5},
+		{"ds_exporter_access_optime_seconds", map[string]string{"op": "search", "result": "32"}, 1, 0.25},
+		{"ds_exporter_access_etime_seconds", map[string]string{"op": "search", "result": "0"}, 1, 0.003},
+	}
+	for _, tt := range tests {
+		count, sum, ok := gatherHistogram(t, c, tt.name, tt.labels)
+		if !ok || count != tt.wantCount || sum != tt.wantSum {
+			t.Errorf("%s%v = %d/%v (present %v), want %d/%v", tt.name, tt.labels, count, sum, ok, tt.wantCount, tt.wantSum)
+		}
+	}
+
+	// conn=13 op=5 was never requested, and conn=12 op=3 was dropped when
+	// the connection closed.
+	if v, _ := gatherValue(t, c, "ds_exporter_access_unmatched_results_total", nil); v != 2 {
+		t.Errorf("unmatched results = %v, want 2", v)
+	}
+	if _, _, ok := gatherHistogram(t, c, "ds_exporter_access_etime_seconds", map[string]string{"op": "modify"}); ok {
+		t.Error("modify on a closed connection should not be observed")
+	}
+}
+
+func TestAccessLogCollector_PendingBounded(t *testing.T) {
+	c := newAccessLogCollector()
+	for i := range maxPendingOps + 10 {
+		c.handleLine(`[31/Jan/2024:12:00:00.100000000 +0000] conn=1 op=` + fmtFloat(float64(i)) + ` SRCH base=""`)
+	}
+	if len(c.pending) > maxPendingOps {
+		t.Errorf("pending = %d, want at most %d", len(c.pending), maxPendingOps)
+	}
+}
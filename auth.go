@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// errBind marks failures of the bind that follows every dial, so they can be
+// told apart from dial and search failures.
+var errBind = errors.New("LDAP bind failed")
+
+// Bind methods accepted by --ldap.bindMethod. EXTERNAL lets 389-DS map the
+// peer credentials of an ldapi:// connection (autobind) or a TLS client
+// certificate to a DN, so no password is needed.
+const (
+	bindMethodSimple   = "simple"
+	bindMethodExternal = "external"
+)
+
+// bindPasswordEnv is read for the bind password when no password file is
+// given. Passwords are deliberately not accepted on the command line.
+const bindPasswordEnv = "DS_EXPORTER_BIND_PASSWORD"
+
+// readBindPassword loads the bind password from file, falling back to the
+// env environment variable. A single trailing newline is stripped so files
+// written by editors or echo work as expected.
+func readBindPassword(file, env string) (string, error) {
+	if file == "" {
+		return os.Getenv(env), nil
+	}
+	b, err := os.ReadFile(file) // #nosec G304 -- path is operator supplied
+	if err != nil {
+		return "", fmt.Errorf("reading bind password file: %w", err)
+	}
+	pw := string(b)
+	pw = strings.TrimSuffix(pw, "\n")
+	pw = strings.TrimSuffix(pw, "\r")
+	return pw, nil
+}
+
+// bind authenticates a freshly dialed connection. With no bind DN
+// configured a simple-bind connection is left anonymous.
+func (m *module) bind(c LDAPClient) error {
+	if m.BindMethod == bindMethodExternal {
+		if err := c.ExternalBind(); err != nil {
+			return fmt.Errorf("%w with SASL EXTERNAL: %v", errBind, err)
+		}
+		return nil
+	}
+	if m.BindDN == "" {
+		return nil
+	}
+	if err := c.Bind(m.BindDN, m.BindPassword); err != nil {
+		return fmt.Errorf("%w as %s: %v", errBind, m.BindDN, err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestReadBindPassword_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pw")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(bindPasswordEnv, "from-env")
+
+	pw, err := readBindPassword(path, bindPasswordEnv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pw != "s3cret" {
+		t.Errorf("password = %q, want %q", pw, "s3cret")
+	}
+}
+
+func TestReadBindPassword_Env(t *testing.T) {
+	t.Setenv(bindPasswordEnv, "from-env")
+
+	pw, err := readBindPassword("", bindPasswordEnv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pw != "from-env" {
+		t.Errorf("password = %q, want %q", pw, "from-env")
+	}
+}
+
+func TestReadBindPassword_MissingFile(t *testing.T) {
+	if _, err := readBindPassword(filepath.Join(t.TempDir(), "missing"), bindPasswordEnv); err == nil {
+		t.Fatal("expected error for missing password file")
+	}
+}
+
+func TestBindLDAP_Anonymous(t *testing.T) {
+	called := false
+	m := &mockLDAP{bindFunc: func(string, string) error { called = true; return nil }}
+	if err := testModule().bind(m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if called {
+		t.Error("Bind should not be called without a bind DN")
+	}
+}
+
+func TestBindLDAP_ExternalError(t *testing.T) {
+	mod := &module{BindMethod: bindMethodExternal}
+	m := &mockLDAP{extBindFunc: func() error { return errors.New("inappropriate authentication") }}
+	if err := mod.bind(m); !errors.Is(err, errBind) {
+		t.Fatalf("err = %v, want errBind", err)
+	}
+}
+
+func TestLDAPI_ExternalBindAndSearch(t *testing.T) {
+	stub, path := newUnixLDAPStub(t, []*ldap.Entry{{
+		DN:         "cn=monitor",
+		Attributes: []*ldap.EntryAttribute{{Name: "threads", Values: []string{"16"}}},
+	}})
+	mod := &module{Timeout: 5 * time.Second, BindMethod: bindMethodExternal}
+	u, err := mod.targetURL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewExporter(u, mod)
+	conn, err := e.getLDAPConn(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		e.releaseLDAPConn(conn, nil)
+		e.closeLDAPConn()
+	}()
+
+	if got := stub.bindLog(); len(got) != 1 || got[0] != "sasl:EXTERNAL" {
+		t.Errorf("binds = %v, want [sasl:EXTERNAL]", got)
+	}
+
+	data, err := searchLDAP(context.Background(), conn, mod.Timeout)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if v, _ := data.Get("cn=monitor", "threads"); v != 16 {
+		t.Errorf("threads = %v, want 16", v)
+	}
+}
+
+func TestLDAPI_ExternalBindRejected(t *testing.T) {
+	stub, path := newUnixLDAPStub(t, nil)
+	stub.bindResult = ldap.LDAPResultInappropriateAuthentication
+
+	mod := &module{Timeout: 5 * time.Second, BindMethod: bindMethodExternal}
+	e := NewExporter("ldapi://"+path, mod)
+	if _, err := e.getLDAPConn(context.Background()); !errors.Is(err, errBind) {
+		t.Fatalf("err = %v, want errBind", err)
+	}
+}
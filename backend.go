@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// backendMetricDefs lists the per-backend cache statistics published under
+// cn=monitor,cn=<backend>,cn=ldbm database,cn=plugins,cn=config.
+var backendMetricDefs = []attrMetricDef{
+	{ldapName: "entrycachehits", help: "Number of entry cache lookups that found the entry", kind: counterKind, label: "backend_entrycachehits"},
+	{ldapName: "entrycachetries", help: "Number of entry cache lookups", kind: counterKind, label: "backend_entrycachetries"},
+	{ldapName: "entrycachehitratio", help: "Percentage of entry cache lookups that found the entry", kind: gaugeKind, label: "backend_entrycachehitratio"},
+	{ldapName: "currententrycachesize", help: "Current size of the entry cache in bytes", kind: gaugeKind, label: "backend_currententrycachesize"},
+	{ldapName: "maxentrycachesize", help: "Configured maximum size of the entry cache in bytes", kind: gaugeKind, label: "backend_maxentrycachesize"},
+	{ldapName: "currententrycachecount", help: "Number of entries in the entry cache", kind: gaugeKind, label: "backend_currententrycachecount"},
+	{ldapName: "dncachehits", help: "Number of DN cache lookups that found the DN", kind: counterKind, label: "backend_dncachehits"},
+	{ldapName: "dncachetries", help: "Number of DN cache lookups", kind: counterKind, label: "backend_dncachetries"},
+	{ldapName: "dncachehitratio", help: "Percentage of DN cache lookups that found the DN", kind: gaugeKind, label: "backend_dncachehitratio"},
+	{ldapName: "currentdncachesize", help: "Current size of the DN cache in bytes", kind: gaugeKind, label: "backend_currentdncachesize"},
+	{ldapName: "maxdncachesize", help: "Configured maximum size of the DN cache in bytes", kind: gaugeKind, label: "backend_maxdncachesize"},
+	{ldapName: "currentdncachecount", help: "Number of DNs in the DN cache", kind: gaugeKind, label: "backend_currentdncachecount"},
+}
+
+// backendCollector follows the backendmonitordn values of cn=monitor, which
+// point outside the cn=monitor subtree, and exports their cache statistics
+// with a backend label.
+type backendCollector struct {
+	descs map[string]*prometheus.Desc
+	attrs []string
+}
+
+func newBackendCollector() *backendCollector {
+	c := &backendCollector{descs: newAttrDescs(backendMetricDefs, []string{"backend"})}
+	for _, m := range backendMetricDefs {
+		c.attrs = append(c.attrs, m.ldapName)
+	}
+	return c
+}
+
+func (c *backendCollector) describe(ch chan<- *prometheus.Desc) {
+	for _, m := range backendMetricDefs {
+		ch <- c.descs[m.ldapName]
+	}
+}
+
+func (c *backendCollector) collect(ctx context.Context, conn LDAPClient, timeout time.Duration, monitor []*ldap.Entry, ch chan<- prometheus.Metric) error {
+	var firstErr error
+	for _, dn := range backendMonitorDNs(monitor) {
+		entry, err := searchBase(ctx, conn, dn, c.attrs, timeout)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		emitAttrs(ch, backendMetricDefs, c.descs, entryFloats(entry), backendName(dn))
+	}
+	return firstErr
+}
+
+// backendMonitorDNs returns every backendmonitordn value of the cn=monitor
+// entry.
+func backendMonitorDNs(monitor []*ldap.Entry) []string {
+	if e := monitorEntry(monitor); e != nil {
+		return e.GetEqualFoldAttributeValues("backendmonitordn")
+	}
+	return nil
+}
+
+// backendName extracts the backend from a DN such as
+// cn=monitor,cn=userRoot,cn=ldbm database,cn=plugins,cn=config.
+func backendName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) < 2 || len(parsed.RDNs[1].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[1].Attributes[0].Value
+}
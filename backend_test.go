@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const userRootMonitorDN = "cn=monitor,cn=userRoot,cn=ldbm database,cn=plugins,cn=config"
+
+// backendMock serves a cn=monitor entry pointing at the given backend entries
+// and answers base searches of those entries.
+func backendMock(backends map[string]*ldap.Entry) *mockLDAP {
+	var dns []string
+	for dn := range backends {
+		dns = append(dns, dn)
+	}
+	return &mockLDAP{
+		searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			if req.BaseDN == "cn=monitor" {
+				return &ldap.SearchResult{Entries: []*ldap.Entry{{
+					DN: "cn=monitor",
+					Attributes: []*ldap.EntryAttribute{
+						{Name: "threads", Values: []string{"16"}},
+						{Name: "backendmonitordn", Values: dns},
+					},
+				}}}, nil
+			}
+			e, ok := backends[req.BaseDN]
+			if !ok {
+				return nil, errors.New("no such object")
+			}
+			if e == nil {
+				return nil, errors.New("backend search failed")
+			}
+			return &ldap.SearchResult{Entries: []*ldap.Entry{e}}, nil
+		},
+		closeFunc: func() error { return nil },
+	}
+}
+
+func TestBackendCollector(t *testing.T) {
+	mock := backendMock(map[string]*ldap.Entry{
+		userRootMonitorDN: {
+			DN: userRootMonitorDN,
+			Attributes: []*ldap.EntryAttribute{
+				{Name: "entrycachehits", Values: []string{"900"}},
+				{Name: "entrycachetries", Values: []string{"1000"}},
+				{Name: "currententrycachesize", Values: []string{"2048"}},
+				{Name: "maxentrycachesize", Values: []string{"4096"}},
+				{Name: "dnCacheHits", Values: []string{"50"}},
+				{Name: "database", Values: []string{"ldbm database"}},
+			},
+		},
+	})
+	e := NewExporter("ldap://ldap.example.com:389", testModule())
+	e.dial = func(_ context.Context, addr string) (LDAPClient, error) { return mock, nil }
+
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{"ds_exporter_backend_entrycachehits", 900},
+		{"ds_exporter_backend_entrycachetries", 1000},
+		{"ds_exporter_backend_currententrycachesize", 2048},
+		{"ds_exporter_backend_maxentrycachesize", 4096},
+		{"ds_exporter_backend_dncachehits", 50},
+	}
+	for _, tt := range tests {
+		got, ok := gatherValue(t, e, tt.name, map[string]string{"backend": "userRoot"})
+		if !ok {
+			t.Errorf("%s{backend=userRoot} missing", tt.name)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if _, ok := gatherValue(t, e, "ds_exporter_backend_dncachetries", nil); ok {
+		t.Error("attributes absent from the entry should not be exported")
+	}
+}
+
+func TestBackendCollector_SearchErrorKeepsUp(t *testing.T) {
+	mock := backendMock(map[string]*ldap.Entry{userRootMonitorDN: nil})
+	e := NewExporter("ldap://ldap.example.com:389", testModule())
+	e.dial = func(_ context.Context, addr string) (LDAPClient, error) { return mock, nil }
+
+	if v, _ := gatherValue(t, e, "ds_exporter_up", nil); v != 1 {
+		t.Errorf("ds_exporter_up = %v, want 1", v)
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_last_scrape_error", nil); v != 1 {
+		t.Errorf("ds_exporter_last_scrape_error = %v, want 1", v)
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_threads", nil); v != 16 {
+		t.Errorf("ds_exporter_threads = %v, want 16", v)
+	}
+}
+
+func TestBackendName(t *testing.T) {
+	tests := map[string]string{
+		userRootMonitorDN: "userRoot",
+		"cn=monitor,cn=ipaca,cn=ldbm database,cn=plugins,cn=config": "ipaca",
+		"cn=monitor": "cn=monitor",
+	}
+	for dn, want := range tests {
+		if got := backendName(dn); got != want {
+			t.Errorf("backendName(%q) = %q, want %q", dn, got, want)
+		}
+	}
+}
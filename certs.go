@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// certCheck is one listener of a target whose certificates are exported:
+// the LDAPS port, or the LDAP port upgraded with StartTLS.
+type certCheck struct {
+	mode string // tlsModeLDAPS or tlsModeStartTLS
+	port string // empty for the target's own port or the mode's default
+}
+
+// parseCertChecks parses the check list of the TLS settings, entries of the
+// form ldaps, starttls, ldaps:3636 or starttls:3389.
+func parseCertChecks(checks []string) ([]certCheck, error) {
+	var parsed []certCheck
+	for _, s := range checks {
+		mode, port, _ := strings.Cut(s, ":")
+		if mode != tlsModeLDAPS && mode != tlsModeStartTLS {
+			return nil, fmt.Errorf("invalid certificate check %q: must be %s or %s, optionally followed by :port", s, tlsModeLDAPS, tlsModeStartTLS)
+		}
+		if strings.Contains(s, ":") && port == "" {
+			return nil, fmt.Errorf("invalid certificate check %q: empty port", s)
+		}
+		parsed = append(parsed, certCheck{mode: mode, port: port})
+	}
+	return parsed, nil
+}
+
+// listener returns the URL to handshake with for target. Without an explicit
+// port the target's own port is used if it speaks the same scheme, or else
+// the default LDAPS or LDAP port.
+func (c certCheck) listener(target *url.URL) *url.URL {
+	scheme, port := "ldap", "389"
+	if c.mode == tlsModeLDAPS {
+		scheme, port = "ldaps", "636"
+	}
+	switch {
+	case c.port != "":
+		port = c.port
+	case target.Scheme == scheme && target.Port() != "":
+		port = target.Port()
+	}
+	return &url.URL{Scheme: scheme, Host: net.JoinHostPort(target.Hostname(), port)}
+}
+
+type certListener struct {
+	mode string
+	url  *url.URL
+}
+
+// certCollector handshakes with the TLS listeners of a target and exports
+// the validity of every certificate of the presented chain, and whether the
+// chain verifies against the module's CA.
+type certCollector struct {
+	mod       *module
+	listeners []certListener
+
+	handshakeDesc *prometheus.Desc
+	verifiedDesc  *prometheus.Desc
+	notAfterDesc  *prometheus.Desc
+	notBeforeDesc *prometheus.Desc
+	infoDesc      *prometheus.Desc
+}
+
+// newCertCollector returns a collector for the certificate checks of mod
+// against target, or nil if there is nothing to check. ldapi:// targets have
+// no TLS listener.
+func newCertCollector(target string, mod *module) *certCollector {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "ldapi" || len(mod.certChecks) == 0 {
+		return nil
+	}
+	c := newCertMetrics()
+	c.mod = mod
+	for _, check := range mod.certChecks {
+		c.listeners = append(c.listeners, certListener{mode: check.mode, url: check.listener(u)})
+	}
+	return c
+}
+
+// newCertMetrics returns a certCollector with its descriptors but no
+// listeners to check.
+func newCertMetrics() *certCollector {
+	labels := []string{"listener", "mode"}
+	certLabels := []string{"listener", "mode", "depth"}
+	desc := func(name, help string, labels []string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+	}
+	return &certCollector{
+		handshakeDesc: desc("tls_handshake_success", "Whether the TLS handshake with the listener succeeded (1) or not (0)", labels),
+		verifiedDesc:  desc("tls_chain_verified", "Whether the certificate chain of the listener verifies against the configured CA and server name (1) or not (0)", labels),
+		notAfterDesc:  desc("tls_cert_not_after_seconds", "Unix time the certificate expires; depth 0 is the server certificate", certLabels),
+		notBeforeDesc: desc("tls_cert_not_before_seconds", "Unix time the certificate becomes valid; depth 0 is the server certificate", certLabels),
+		infoDesc: desc("tls_cert_info", "Subject, issuer, subject alternative names and serial number of the certificate",
+			append(certLabels, "subject", "issuer", "sans", "serial")),
+	}
+}
+
+func (c *certCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.handshakeDesc
+	ch <- c.verifiedDesc
+	ch <- c.notAfterDesc
+	ch <- c.notBeforeDesc
+	ch <- c.infoDesc
+}
+
+func (c *certCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	for _, l := range c.listeners {
+		labels := []string{l.url.Host, l.mode}
+		chain, err := c.inspect(ctx, l)
+		if err != nil {
+			log.Printf("Error reading the certificates of %s (%s): %v", l.url.Host, l.mode, err)
+			ch <- prometheus.MustNewConstMetric(c.handshakeDesc, prometheus.GaugeValue, 0, labels...)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.handshakeDesc, prometheus.GaugeValue, 1, labels...)
+
+		verified := 1.0
+		if err := verifyChain(chain, c.mod, l.url.Hostname()); err != nil {
+			log.Printf("Certificate chain of %s (%s) does not verify: %v", l.url.Host, l.mode, err)
+			verified = 0
+		}
+		ch <- prometheus.MustNewConstMetric(c.verifiedDesc, prometheus.GaugeValue, verified, labels...)
+
+		for i, cert := range chain {
+			certLabels := append(labels[:2:2], fmt.Sprint(i))
+			ch <- prometheus.MustNewConstMetric(c.notAfterDesc, prometheus.GaugeValue, float64(cert.NotAfter.Unix()), certLabels...)
+			ch <- prometheus.MustNewConstMetric(c.notBeforeDesc, prometheus.GaugeValue, float64(cert.NotBefore.Unix()), certLabels...)
+			ch <- prometheus.MustNewConstMetric(c.infoDesc, prometheus.GaugeValue, 1,
+				append(certLabels, cert.Subject.String(), cert.Issuer.String(), certSANs(cert), cert.SerialNumber.Text(16))...)
+		}
+	}
+}
+
+// inspect handshakes with the listener, within the module timeout, and
+// returns the chain the server presented. The chain is read even when it
+// would not verify, so an expired or untrusted certificate is still
+// reported.
+func (c *certCollector) inspect(ctx context.Context, l certListener) ([]*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.mod.Timeout)
+	defer cancel()
+
+	cfg := c.mod.tlsConfig.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = l.url.Hostname()
+	}
+	cfg.InsecureSkipVerify = true // #nosec G402 -- the chain is checked by verifyChain
+	conn, err := dialConn(ctx, l.url, l.mode == tlsModeStartTLS, cfg, c.mod.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	state, ok := conn.conn.TLSConnectionState()
+	if !ok || len(state.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("%w with %s: no certificate presented", errTLS, l.url.Host)
+	}
+	return state.PeerCertificates, nil
+}
+
+// verifyChain verifies chain the way the exporter's own connections do:
+// against the module CA, or the system roots, for the configured server name
+// or else host.
+func verifyChain(chain []*x509.Certificate, mod *module, host string) error {
+	opts := x509.VerifyOptions{
+		DNSName:       host,
+		Intermediates: x509.NewCertPool(),
+	}
+	if mod.tlsConfig != nil {
+		opts.Roots = mod.tlsConfig.RootCAs
+		if mod.tlsConfig.ServerName != "" {
+			opts.DNSName = mod.tlsConfig.ServerName
+		}
+	}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(opts)
+	return err
+}
+
+// certSANs lists the subject alternative names of cert in the form openssl
+// prints them, e.g. DNS:ds1.example.com,IP:192.0.2.1.
+func certSANs(cert *x509.Certificate) string {
+	var sans []string
+	for _, n := range cert.DNSNames {
+		sans = append(sans, "DNS:"+n)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, e := range cert.EmailAddresses {
+		sans = append(sans, "email:"+e)
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, "URI:"+u.String())
+	}
+	return strings.Join(sans, ",")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseCertChecks(t *testing.T) {
+	got, err := parseCertChecks([]string{"ldaps", "starttls:3389"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []certCheck{{mode: tlsModeLDAPS}, {mode: tlsModeStartTLS, port: "3389"}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("parsed %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{"tls", "ldaps:", "none"} {
+		if _, err := parseCertChecks([]string{bad}); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestCertCheckListener(t *testing.T) {
+	tests := []struct {
+		check  certCheck
+		target string
+		want   string
+	}{
+		{certCheck{mode: tlsModeLDAPS}, "ldap://ds1.example.com:389", "ldaps://ds1.example.com:636"},
+		{certCheck{mode: tlsModeLDAPS}, "ldaps://ds1.example.com:3636", "ldaps://ds1.example.com:3636"},
+		{certCheck{mode: tlsModeStartTLS}, "ldap://ds1.example.com:3389", "ldap://ds1.example.com:3389"},
+		{certCheck{mode: tlsModeStartTLS}, "ldaps://ds1.example.com:636", "ldap://ds1.example.com:389"},
+		{certCheck{mode: tlsModeLDAPS, port: "10636"}, "ldaps://ds1.example.com:636", "ldaps://ds1.example.com:10636"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.target)
+		if got := tt.check.listener(u).String(); got != tt.want {
+			t.Errorf("%+v against %s = %s, want %s", tt.check, tt.target, got, tt.want)
+		}
+	}
+}
+
+// certExporter returns an exporter for target that checks the certificates
+// of check and whose own connections always fail.
+func certExporter(t *testing.T, target, check, caFile string) *Exporter {
+	t.Helper()
+	mod := &module{Timeout: 5 * time.Second, TLS: tlsSettings{CAFile: caFile, Check: []string{check}}}
+	if err := mod.validate(); err != nil {
+		t.Fatal(err)
+	}
+	e := NewExporter(target, mod)
+	e.dial = func(context.Context, string) (LDAPClient, error) {
+		return nil, errors.New("connection refused")
+	}
+	return e
+}
+
+func TestCertCollector_LDAPS(t *testing.T) {
+	p := newTestPKI(t)
+	addr := startTLSListener(t, p.leaf)
+	e := certExporter(t, "ldaps://"+addr, tlsModeLDAPS, p.caFile)
+
+	labels := map[string]string{"listener": addr, "mode": tlsModeLDAPS}
+	if v, ok := gatherValue(t, e, "ds_exporter_tls_handshake_success", labels); !ok || v != 1 {
+		t.Errorf("handshake success = %v (present %v), want 1", v, ok)
+	}
+	if v, ok := gatherValue(t, e, "ds_exporter_tls_chain_verified", labels); !ok || v != 1 {
+		t.Errorf("chain verified = %v (present %v), want 1", v, ok)
+	}
+	leaf := map[string]string{"listener": addr, "depth": "0"}
+	if v, _ := gatherValue(t, e, "ds_exporter_tls_cert_not_after_seconds", leaf); v != float64(p.leaf.Leaf.NotAfter.Unix()) {
+		t.Errorf("not after = %v, want %d", v, p.leaf.Leaf.NotAfter.Unix())
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_tls_cert_not_before_seconds", leaf); v != float64(p.leaf.Leaf.NotBefore.Unix()) {
+		t.Errorf("not before = %v, want %d", v, p.leaf.Leaf.NotBefore.Unix())
+	}
+	info := map[string]string{"depth": "0", "subject": "CN=localhost", "issuer": "CN=test CA", "sans": "DNS:localhost,IP:127.0.0.1", "serial": "2"}
+	if v, ok := gatherValue(t, e, "ds_exporter_tls_cert_info", info); !ok || v != 1 {
+		t.Errorf("cert info = %v (present %v), want 1", v, ok)
+	}
+	// The certificates are exported even though the scrape failed.
+	if v, _ := gatherValue(t, e, "ds_exporter_up", nil); v != 0 {
+		t.Errorf("up = %v, want 0", v)
+	}
+}
+
+func TestCertCollector_StartTLSUntrusted(t *testing.T) {
+	p := newTestPKI(t)
+	addr := newStartTLSLDAPStub(t, p.leaf)
+	other := newTestPKI(t)
+	e := certExporter(t, "ldap://"+addr, tlsModeStartTLS, other.caFile)
+
+	labels := map[string]string{"listener": addr, "mode": tlsModeStartTLS}
+	if v, ok := gatherValue(t, e, "ds_exporter_tls_handshake_success", labels); !ok || v != 1 {
+		t.Errorf("handshake success = %v (present %v), want 1", v, ok)
+	}
+	if v, ok := gatherValue(t, e, "ds_exporter_tls_chain_verified", labels); !ok || v != 0 {
+		t.Errorf("chain verified = %v (present %v), want 0 against another CA", v, ok)
+	}
+	if _, ok := gatherValue(t, e, "ds_exporter_tls_cert_not_after_seconds", labels); !ok {
+		t.Error("an untrusted chain should still have its expiry exported")
+	}
+}
+
+func TestCertCollector_HandshakeFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	e := certExporter(t, "ldaps://"+addr, tlsModeLDAPS, "")
+
+	if v, ok := gatherValue(t, e, "ds_exporter_tls_handshake_success", nil); !ok || v != 0 {
+		t.Errorf("handshake success = %v (present %v), want 0", v, ok)
+	}
+	if _, ok := gatherValue(t, e, "ds_exporter_tls_cert_not_after_seconds", nil); ok {
+		t.Error("no certificate metrics expected without a handshake")
+	}
+}
+
+func TestNewCertCollector_LDAPI(t *testing.T) {
+	mod := &module{Timeout: time.Second, TLS: tlsSettings{Check: []string{tlsModeLDAPS}}}
+	if err := mod.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c := newCertCollector("ldapi:///run/slapd.socket", mod); c != nil {
+		t.Error("an ldapi target has no TLS listener to check")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/ozgurcd/389DS-exporter/obj"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type metricKind int
+
+const (
+	gaugeKind metricKind = iota
+	counterKind
+)
+
+// collector gathers one group of metrics over an established connection.
+// monitor holds the cn=monitor subtree already read by the exporter; ctx is
+// the context of the scrape.
+type collector interface {
+	describe(ch chan<- *prometheus.Desc)
+	collect(ctx context.Context, conn LDAPClient, timeout time.Duration, monitor []*ldap.Entry, ch chan<- prometheus.Metric) error
+}
+
+// contextCollector is a prometheus.Collector whose LDAP operations can be
+// bounded by the context of the request being served.
+type contextCollector interface {
+	prometheus.Collector
+	CollectContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+// scrapeCollector binds a contextCollector to the context of one scrape.
+type scrapeCollector struct {
+	contextCollector
+	ctx context.Context
+}
+
+func (s scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	s.CollectContext(s.ctx, ch)
+}
+
+// describeBuiltin sends the descriptors of every metric the exporter defines
+// itself, whatever the configuration. The metric and label names of the
+// configuration file are checked against it; a new collector must describe
+// its metrics here. Query metrics and discovered attributes are not built in.
+func describeBuiltin(ch chan<- *prometheus.Desc) {
+	newScrapeMetrics().describe(ch)
+	for _, c := range newTargetCollectors(newRUVCollector()) {
+		c.describe(ch)
+	}
+	newCertMetrics().describe(ch)
+	newSyntheticCollector(nil, nil).describe(ch)
+	newPoller(nil, 0).describeSnapshot(ch)
+	newAccessLogCollector().Describe(ch)
+	newErrorsLogCollector(nil).Describe(ch)
+	newReplicationLagCollector(nil, 0).Describe(ch)
+}
+
+// describer is a collector that describes metrics and collects nothing, to
+// check descriptors against a registry.
+type describer func(ch chan<- *prometheus.Desc)
+
+func (d describer) Describe(ch chan<- *prometheus.Desc) { d(ch) }
+
+func (d describer) Collect(chan<- prometheus.Metric) {}
+
+// attrMetricDef maps one numeric monitor attribute to a metric.
+type attrMetricDef struct {
+	ldapName string
+	help     string
+	kind     metricKind
+	label    string
+}
+
+func (k metricKind) valueType() prometheus.ValueType {
+	if k == counterKind {
+		return prometheus.CounterValue
+	}
+	return prometheus.GaugeValue
+}
+
+// newAttrDescs builds one descriptor per definition, keyed by attribute name.
+func newAttrDescs(defs []attrMetricDef, labels []string) map[string]*prometheus.Desc {
+	descs := make(map[string]*prometheus.Desc, len(defs))
+	for _, m := range defs {
+		descs[m.ldapName] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", m.label),
+			m.help, labels, nil,
+		)
+	}
+	return descs
+}
+
+// emitAttrs sends a metric for every definition whose attribute is present in
+// values. Absent attributes are skipped rather than reported as zero.
+func emitAttrs(ch chan<- prometheus.Metric, defs []attrMetricDef, descs map[string]*prometheus.Desc, values obj.Samples, labelValues ...string) {
+	for _, m := range defs {
+		v, ok := values[m.ldapName]
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(descs[m.ldapName], m.kind.valueType(), v, labelValues...)
+	}
+}
+
+// monitorEntry returns the cn=monitor entry itself, or nil if monitor does
+// not hold it.
+func monitorEntry(monitor []*ldap.Entry) *ldap.Entry {
+	for _, e := range monitor {
+		if strings.EqualFold(e.DN, "cn=monitor") {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"go.yaml.in/yaml/v3"
+)
+
+// fileConfig is the layout of --config.file.
+//
+//	modules:
+//	  secure:
+//	    timeout: 5s
+//	    bind_dn: cn=exporter,cn=config
+//	    bind_password_file: /etc/ds_exporter/password
+//	    tls:
+//	      mode: ldaps
+//	      ca_file: /etc/pki/ca.pem
+//	targets:
+//	  - name: ds1
+//	    uri: ldaps://ds1.example.com:636
+//	    module: secure
+//	    labels:
+//	      site: east
+//	discovery:
+//	  enabled: true
+//	queries:
+//	  - name: people
+//	    base: dc=example,dc=com
+//	    filter: (objectClass=person)
+//	    metrics:
+//	      - name: ds_exporter_query_people
+//	synthetic:
+//	  - name: app_login
+//	    base: dc=example,dc=com
+//	errors_log:
+//	  rules:
+//	    - name: replication_bind_failed
+//	      match: Replication bind .* failed
+type fileConfig struct {
+	Modules   map[string]moduleConfig `yaml:"modules"`
+	Targets   []targetConfig          `yaml:"targets"`
+	Discovery discoverySettings       `yaml:"discovery"`
+	Queries   []queryConfig           `yaml:"queries"`
+	Synthetic []syntheticConfig       `yaml:"synthetic"`
+	ErrorsLog errorsLogSettings       `yaml:"errors_log"`
+}
+
+// moduleConfig is the file form of a module. Passwords are never inline; they
+// are read from bind_password_file or the bind_password_env variable.
+type moduleConfig struct {
+	Timeout          time.Duration `yaml:"timeout"`
+	BindMethod       string        `yaml:"bind_method"`
+	BindDN           string        `yaml:"bind_dn"`
+	BindPasswordFile string        `yaml:"bind_password_file"`
+	BindPasswordEnv  string        `yaml:"bind_password_env"`
+	TLS              tlsSettings   `yaml:"tls"`
+}
+
+// targetConfig declares one directory server. Settings given inline override
+// those of the referenced module.
+type targetConfig struct {
+	Name         string            `yaml:"name"`
+	URI          string            `yaml:"uri"`
+	Module       string            `yaml:"module"`
+	Labels       map[string]string `yaml:"labels"`
+	moduleConfig `yaml:",inline"`
+}
+
+// scrapeTarget is a validated target ready to be collected.
+type scrapeTarget struct {
+	Name   string
+	URL    string
+	Module *module
+	Labels map[string]string
+}
+
+// loadConfig reads and strictly decodes a configuration file. Unknown keys
+// are rejected so typos do not silently fall back to defaults.
+func loadConfig(path string) (*fileConfig, error) {
+	b, err := os.ReadFile(path) // #nosec G304 -- path is operator supplied
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+
+	var cfg fileConfig
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// mergeTLS returns base with every non-zero setting of over applied on top.
+func mergeTLS(base, over tlsSettings) tlsSettings {
+	if over.Mode != "" {
+		base.Mode = over.Mode
+	}
+	if over.CAFile != "" {
+		base.CAFile = over.CAFile
+	}
+	if over.CertFile != "" {
+		base.CertFile = over.CertFile
+	}
+	if over.KeyFile != "" {
+		base.KeyFile = over.KeyFile
+	}
+	if over.ServerName != "" {
+		base.ServerName = over.ServerName
+	}
+	if over.MinVersion != "" {
+		base.MinVersion = over.MinVersion
+	}
+	if len(over.Check) > 0 {
+		base.Check = over.Check
+	}
+	return base
+}
+
+// password reads the bind password from bind_password_file, or from
+// bind_password_env (default $DS_EXPORTER_BIND_PASSWORD).
+func (c moduleConfig) password() (string, error) {
+	env := c.BindPasswordEnv
+	if env == "" {
+		env = bindPasswordEnv
+	}
+	return readBindPassword(c.BindPasswordFile, env)
+}
+
+// build turns the file form into a validated module. defaultTimeout comes
+// from --ldap.timeout.
+func (c moduleConfig) build(defaultTimeout time.Duration) (*module, error) {
+	m := &module{
+		Timeout:    c.Timeout,
+		BindMethod: c.BindMethod,
+		BindDN:     c.BindDN,
+		TLS:        c.TLS,
+	}
+	if m.Timeout == 0 {
+		m.Timeout = defaultTimeout
+	}
+	if c.BindDN != "" {
+		pw, err := c.password()
+		if err != nil {
+			return nil, err
+		}
+		m.BindPassword = pw
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// resolve validates the configuration and returns its modules and targets.
+// defaults holds the modules built from command line flags; modules in the
+// file with the same name replace them.
+func (c *fileConfig) resolve(defaults map[string]*module, defaultTimeout time.Duration) (map[string]*module, []scrapeTarget, error) {
+	mods := make(map[string]*module, len(defaults)+len(c.Modules))
+	for name, m := range defaults {
+		mods[name] = m
+	}
+	for name, mc := range c.Modules {
+		if name == "" {
+			return nil, nil, fmt.Errorf("module with empty name")
+		}
+		m, err := mc.build(defaultTimeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("module %q: %w", name, err)
+		}
+		mods[name] = m
+	}
+
+	seen := make(map[string]bool, len(c.Targets))
+	targets := make([]scrapeTarget, 0, len(c.Targets))
+	for i, tc := range c.Targets {
+		if tc.Name == "" {
+			return nil, nil, fmt.Errorf("target #%d: name is required", i+1)
+		}
+		if seen[tc.Name] {
+			return nil, nil, fmt.Errorf("target %q: duplicate name", tc.Name)
+		}
+		seen[tc.Name] = true
+
+		st, err := tc.resolve(mods)
+		if err != nil {
+			return nil, nil, fmt.Errorf("target %q: %w", tc.Name, err)
+		}
+		targets = append(targets, st)
+	}
+
+	if err := c.Discovery.validate(); err != nil {
+		return nil, nil, err
+	}
+	return mods, targets, nil
+}
+
+func (tc targetConfig) resolve(mods map[string]*module) (scrapeTarget, error) {
+	if tc.URI == "" {
+		return scrapeTarget{}, fmt.Errorf("uri is required")
+	}
+	for name := range tc.Labels {
+		if !model.LabelName(name).IsValidLegacy() {
+			return scrapeTarget{}, fmt.Errorf("invalid label name %q", name)
+		}
+		if name == "target" {
+			return scrapeTarget{}, fmt.Errorf("label name %q is reserved", name)
+		}
+	}
+
+	name := tc.Module
+	if name == "" {
+		name = defaultModuleName
+	}
+	mod, ok := mods[name]
+	if !ok {
+		return scrapeTarget{}, fmt.Errorf("unknown module %q", name)
+	}
+	if !reflect.ValueOf(tc.moduleConfig).IsZero() {
+		var err error
+		if mod, err = mod.withOverrides(tc.moduleConfig); err != nil {
+			return scrapeTarget{}, err
+		}
+	}
+
+	u, err := mod.targetURL(tc.URI)
+	if err != nil {
+		return scrapeTarget{}, err
+	}
+	return scrapeTarget{Name: tc.Name, URL: u, Module: mod, Labels: tc.Labels}, nil
+}
+
+// withOverrides returns a private copy of m with the non-zero settings of
+// over applied, for targets that tweak their module inline.
+func (m *module) withOverrides(over moduleConfig) (*module, error) {
+	c := *m
+	if over.Timeout != 0 {
+		c.Timeout = over.Timeout
+	}
+	if over.BindMethod != "" {
+		c.BindMethod = over.BindMethod
+	}
+	// SASL EXTERNAL takes the identity from the socket or the client
+	// certificate, so the inherited simple bind credentials are dropped.
+	if over.BindMethod == bindMethodExternal {
+		c.BindDN, c.BindPassword = "", ""
+	}
+	if over.BindDN != "" || over.BindPasswordFile != "" || over.BindPasswordEnv != "" {
+		if over.BindDN != "" {
+			c.BindDN = over.BindDN
+		}
+		pw, err := over.password()
+		if err != nil {
+			return nil, err
+		}
+		c.BindPassword = pw
+	}
+	c.TLS = mergeTLS(c.TLS, over.TLS)
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// registerer wraps reg so every metric of the target carries its name and
+// constant labels. Targets from the command line have no name and are
+// registered unchanged.
+func (t scrapeTarget) registerer(reg prometheus.Registerer) prometheus.Registerer {
+	if t.Name == "" {
+		return reg
+	}
+	labels := prometheus.Labels{"target": t.Name}
+	for k, v := range t.Labels {
+		labels[k] = v
+	}
+	return prometheus.WrapRegistererWith(labels, reg)
+}
+
+// checkLabels makes sure no constant label of t is also a variable label of
+// one of the exporter's metrics, e.g. backend or partition, which would make
+// every registration of the target fail. It must run once the queries are
+// built, as their labels count too.
+func (t scrapeTarget) checkLabels() error {
+	names := make([]string, 0, len(t.Labels))
+	for name := range t.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := describer(func(ch chan<- *prometheus.Desc) {
+		describeBuiltin(ch)
+		newQueryCollector(queries).describe(ch)
+	})
+	for _, name := range names {
+		reg := prometheus.WrapRegistererWith(prometheus.Labels{name: t.Labels[name]}, prometheus.NewPedanticRegistry())
+		if err := reg.Register(metrics); err != nil {
+			return fmt.Errorf("label %q clashes with a label of the exporter's metrics: %w", name, err)
+		}
+	}
+	return nil
+}
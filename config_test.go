@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func resolveConfig(t *testing.T, body string) (map[string]*module, []scrapeTarget, error) {
+	t.Helper()
+	cfg, err := loadConfig(writeConfig(t, body))
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg.resolve(map[string]*module{defaultModuleName: testModule()}, 10*time.Second)
+}
+
+func TestLoadConfig_Full(t *testing.T) {
+	dir := t.TempDir()
+	pwFile := filepath.Join(dir, "pw")
+	if err := os.WriteFile(pwFile, []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DS2_PASSWORD", "other")
+
+	mods, targets, err := resolveConfig(t, `
+modules:
+  secure:
+    timeout: 3s
+    bind_dn: cn=exporter,cn=config
+    bind_password_file: `+pwFile+`
+    tls:
+      mode: starttls
+      min_version: "1.3"
+targets:
+  - name: ds1
+    uri: ds1.example.com:389
+    module: secure
+    labels:
+      site: east
+  - name: ds2
+    uri: ldap://ds2.example.com:389
+    module: secure
+    bind_dn: cn=other,cn=config
+    bind_password_env: DS2_PASSWORD
+    timeout: 7s
+  - name: local
+    uri: /run/slapd-example.socket
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secure := mods["secure"]
+	if secure == nil || secure.Timeout != 3*time.Second || secure.BindPassword != "hunter2" {
+		t.Fatalf("secure module = %+v", secure)
+	}
+	if _, ok := mods[defaultModuleName]; !ok {
+		t.Error("flag module should remain available")
+	}
+
+	if len(targets) != 3 {
+		t.Fatalf("got %d targets, want 3", len(targets))
+	}
+	ds1, ds2, local := targets[0], targets[1], targets[2]
+
+	if ds1.URL != "ldap://ds1.example.com:389" || ds1.Module != secure || ds1.Labels["site"] != "east" {
+		t.Errorf("ds1 = %+v", ds1)
+	}
+
+	if ds2.Module == secure {
+		t.Error("inline overrides must not modify the shared module")
+	}
+	if ds2.Module.BindDN != "cn=other,cn=config" || ds2.Module.BindPassword != "other" || ds2.Module.Timeout != 7*time.Second {
+		t.Errorf("ds2 module = %+v", ds2.Module)
+	}
+	if ds2.Module.TLS.Mode != tlsModeStartTLS {
+		t.Errorf("ds2 should inherit TLS mode, got %q", ds2.Module.TLS.Mode)
+	}
+
+	if local.URL != "ldapi:///run/slapd-example.socket" {
+		t.Errorf("local URL = %q", local.URL)
+	}
+}
+
+func TestLoadConfig_ExternalOverride(t *testing.T) {
+	t.Setenv("DS_PASSWORD", "secret")
+	_, targets, err := resolveConfig(t, `
+modules:
+  simple:
+    bind_dn: cn=exporter,cn=config
+    bind_password_env: DS_PASSWORD
+targets:
+  - name: local
+    uri: /run/slapd-example.socket
+    module: simple
+    bind_method: external
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mod := targets[0].Module
+	if mod.BindMethod != bindMethodExternal || mod.BindDN != "" || mod.BindPassword != "" {
+		t.Errorf("local module = %+v, want external without simple bind credentials", mod)
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"unknown key", "targets:\n  - name: ds1\n    url: ds1:389\n", "field url not found"},
+		{"missing name", "targets:\n  - uri: ds1:389\n", "target #1: name is required"},
+		{"missing uri", "targets:\n  - name: ds1\n", `target "ds1": uri is required`},
+		{"duplicate", "targets:\n  - name: ds1\n    uri: ds1:389\n  - name: ds1\n    uri: ds2:389\n", "duplicate name"},
+		{"unknown module", "targets:\n  - name: ds1\n    uri: ds1:389\n    module: nope\n", `unknown module "nope"`},
+		{"bad label", "targets:\n  - name: ds1\n    uri: ds1:389\n    labels:\n      bad-label: x\n", "invalid label name"},
+		{"reserved label", "targets:\n  - name: ds1\n    uri: ds1:389\n    labels:\n      target: x\n", "reserved"},
+		{"bad module", "modules:\n  m:\n    bind_method: kerberos\n", `module "m": invalid bind method`},
+		{"bad tls", "modules:\n  m:\n    tls:\n      mode: ssl\n", `module "m": invalid TLS configuration`},
+		{"bad duration", "modules:\n  m:\n    timeout: soon\n", "time.Duration"},
+		{"bad discovery type", "discovery:\n  enabled: true\n  types:\n    threads: summary\n", "must be counter or gauge"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := resolveConfig(t, tt.body)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Empty(t *testing.T) {
+	mods, targets, err := resolveConfig(t, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(targets) != 0 || len(mods) != 1 {
+		t.Errorf("got %d modules and %d targets, want 1 and 0", len(mods), len(targets))
+	}
+}
+
+func TestScrapeTargetCheckLabels(t *testing.T) {
+	ok := scrapeTarget{Name: "ds1", URL: "ldap://ds1:389", Module: testModule(), Labels: map[string]string{"site": "east"}}
+	if err := ok.checkLabels(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"stage", "state", "backend", "suffix", "partition", "plugin", "severity", "probe"} {
+		tgt := scrapeTarget{Name: "ds1", URL: "ldap://ds1:389", Module: testModule(), Labels: map[string]string{"site": "east", name: "x"}}
+		err := tgt.checkLabels()
+		if err == nil {
+			t.Errorf("label %s: expected error", name)
+			continue
+		}
+		if !strings.Contains(err.Error(), `label "`+name+`"`) {
+			t.Errorf("error %q does not name label %s", err, name)
+		}
+	}
+}
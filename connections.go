@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Bind DN label values for connections that are not labelled individually.
+const (
+	anonymousBindDN = "anonymous"
+	otherBindDN     = "other"
+)
+
+// opsPendingBuckets are the upper bounds of the ops pending histogram.
+var opsPendingBuckets = []float64{0, 1, 2, 5, 10, 25, 50}
+
+// connectionLabelSettings bounds the cardinality of the bind_dn label.
+// Connections bound as a DN outside BindDNs, or outside the TopN most
+// frequent ones when BindDNs is empty, are counted as "other".
+type connectionLabelSettings struct {
+	BindDNs []string
+	TopN    int
+}
+
+// connectionLabels is set from --connections.bindDNs and
+// --connections.topBindDNs.
+var connectionLabels = connectionLabelSettings{TopN: 10}
+
+// connInfo is one value of the multi-valued connection attribute of
+// cn=monitor, which has the form
+// fd:opentime:opsinitiated:opscompleted:rw:binddn:... .
+type connInfo struct {
+	OpenTime      time.Time
+	OpsInitiated  float64
+	OpsCompleted  float64
+	BindDN        string
+	hasOpenTime   bool
+	hasOpCounters bool
+}
+
+// parseConnection decodes a connection value. The bind DN is taken up to
+// the next colon; the trailing fields differ between server versions and
+// are ignored.
+func parseConnection(v string) (connInfo, bool) {
+	fields := strings.SplitN(v, ":", 7)
+	if len(fields) < 6 {
+		return connInfo{}, false
+	}
+	var c connInfo
+	if t, err := time.Parse(generalizedTimeLayout, fields[1]); err == nil {
+		c.OpenTime, c.hasOpenTime = t, true
+	}
+	initiated, err1 := strconv.ParseFloat(fields[2], 64)
+	completed, err2 := strconv.ParseFloat(fields[3], 64)
+	if err1 == nil && err2 == nil {
+		c.OpsInitiated, c.OpsCompleted, c.hasOpCounters = initiated, completed, true
+	}
+	c.BindDN = strings.ToLower(strings.TrimSpace(fields[5]))
+	if c.BindDN == "" || c.BindDN == "nulldn" {
+		c.BindDN = anonymousBindDN
+	}
+	return c, true
+}
+
+// connectionCollector aggregates the connection values of cn=monitor. Per
+// connection labels would be unbounded, so only per bind DN counts, the
+// oldest connection age and the distribution of pending operations are
+// exported.
+type connectionCollector struct {
+	settings      connectionLabelSettings
+	byBindDNDesc  *prometheus.Desc
+	oldestAgeDesc *prometheus.Desc
+	pendingDesc   *prometheus.Desc
+	pendingMax    *prometheus.Desc
+}
+
+func newConnectionCollector(settings connectionLabelSettings) *connectionCollector {
+	return &connectionCollector{
+		settings: settings,
+		byBindDNDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "connections_by_bind_dn"),
+			"Number of open connections by bind DN; DNs beyond the configured allow-list or top N are counted as other",
+			[]string{"bind_dn"}, nil,
+		),
+		oldestAgeDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "connection_oldest_age_seconds"),
+			"Age of the oldest open connection", nil, nil,
+		),
+		pendingDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "connection_ops_pending"),
+			"Distribution of operations initiated but not completed per open connection", nil, nil,
+		),
+		pendingMax: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "connection_ops_pending_max"),
+			"Highest number of operations pending on a single open connection", nil, nil,
+		),
+	}
+}
+
+func (c *connectionCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.byBindDNDesc
+	ch <- c.oldestAgeDesc
+	ch <- c.pendingDesc
+	ch <- c.pendingMax
+}
+
+func (c *connectionCollector) collect(ctx context.Context, _ LDAPClient, _ time.Duration, monitor []*ldap.Entry, ch chan<- prometheus.Metric) error {
+	e := monitorEntry(monitor)
+	if e == nil {
+		return nil
+	}
+	values := e.GetEqualFoldAttributeValues("connection")
+	if len(values) == 0 {
+		return nil
+	}
+	// Age connections against the server clock so skew between the
+	// exporter and the server does not distort them.
+	now := time.Now()
+	if t, err := time.Parse(generalizedTimeLayout, e.GetEqualFoldAttributeValue("currenttime")); err == nil {
+		now = t
+	}
+
+	byDN := make(map[string]float64)
+	var oldest time.Time
+	var pendingCount uint64
+	var pendingSum, pendingMax float64
+	buckets := make(map[float64]uint64, len(opsPendingBuckets))
+	for _, v := range values {
+		conn, ok := parseConnection(v)
+		if !ok {
+			continue
+		}
+		byDN[conn.BindDN]++
+		if conn.hasOpenTime && (oldest.IsZero() || conn.OpenTime.Before(oldest)) {
+			oldest = conn.OpenTime
+		}
+		if conn.hasOpCounters {
+			pending := max(conn.OpsInitiated-conn.OpsCompleted, 0)
+			pendingCount++
+			pendingSum += pending
+			pendingMax = max(pendingMax, pending)
+			for _, b := range opsPendingBuckets {
+				if pending <= b {
+					buckets[b]++
+				}
+			}
+		}
+	}
+
+	for dn, n := range c.settings.limit(byDN) {
+		ch <- prometheus.MustNewConstMetric(c.byBindDNDesc, prometheus.GaugeValue, n, dn)
+	}
+	if !oldest.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.oldestAgeDesc, prometheus.GaugeValue, max(now.Sub(oldest).Seconds(), 0))
+	}
+	if pendingCount > 0 {
+		ch <- prometheus.MustNewConstHistogram(c.pendingDesc, pendingCount, pendingSum, buckets)
+		ch <- prometheus.MustNewConstMetric(c.pendingMax, prometheus.GaugeValue, pendingMax)
+	}
+	return nil
+}
+
+// limit folds the bind DNs that should not get their own label value into
+// "other".
+func (s connectionLabelSettings) limit(byDN map[string]float64) map[string]float64 {
+	keep := make(map[string]bool)
+	if len(s.BindDNs) > 0 {
+		for _, dn := range s.BindDNs {
+			keep[strings.ToLower(strings.TrimSpace(dn))] = true
+		}
+	} else {
+		dns := make([]string, 0, len(byDN))
+		for dn := range byDN {
+			dns = append(dns, dn)
+		}
+		sort.Slice(dns, func(i, j int) bool {
+			if byDN[dns[i]] != byDN[dns[j]] {
+				return byDN[dns[i]] > byDN[dns[j]]
+			}
+			return dns[i] < dns[j]
+		})
+		for i := 0; i < len(dns) && i < s.TopN; i++ {
+			keep[dns[i]] = true
+		}
+	}
+
+	out := make(map[string]float64, len(keep)+1)
+	for dn, n := range byDN {
+		if keep[dn] {
+			out[dn] += n
+		} else {
+			out[otherBindDN] += n
+		}
+	}
+	return out
+}
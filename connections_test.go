@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func connectionMonitor(conns ...string) []*ldap.Entry {
+	return []*ldap.Entry{{
+		DN: "cn=monitor",
+		Attributes: []*ldap.EntryAttribute{
+			{Name: "currenttime", Values: []string{"20240131120000Z"}},
+			{Name: "connection", Values: conns},
+		},
+	}}
+}
+
+// collectorFunc adapts a collector fed with fixed monitor entries, and an
+// optional connection, to prometheus.Collector.
+type collectorFunc struct {
+	c       collector
+	conn    LDAPClient
+	monitor []*ldap.Entry
+}
+
+func (f collectorFunc) Describe(ch chan<- *prometheus.Desc) { f.c.describe(ch) }
+
+func (f collectorFunc) Collect(ch chan<- prometheus.Metric) {
+	_ = f.c.collect(context.Background(), f.conn, testModule().Timeout, f.monitor, ch)
+}
+
+func TestParseConnection(t *testing.T) {
+	c, ok := parseConnection("64:20240131115000Z:12:10:-:cn=Directory Manager:0:0:0:1:ip=127.0.0.1")
+	if !ok {
+		t.Fatal("expected connection to parse")
+	}
+	if c.BindDN != "cn=directory manager" || c.OpsInitiated != 12 || c.OpsCompleted != 10 || c.OpenTime.Unix() != 1706701800 {
+		t.Errorf("parseConnection = %+v", c)
+	}
+
+	if c, _ := parseConnection("65:20240131115000Z:1:1:-:NULLDN:0:0:0:1:ip=::1"); c.BindDN != anonymousBindDN {
+		t.Errorf("bind DN = %q, want %q", c.BindDN, anonymousBindDN)
+	}
+	if _, ok := parseConnection("garbage"); ok {
+		t.Error("expected garbage to be rejected")
+	}
+}
+
+func TestConnectionCollector(t *testing.T) {
+	c := collectorFunc{
+		c: newConnectionCollector(connectionLabelSettings{TopN: 1}),
+		monitor: connectionMonitor(
+			"64:20240131110000Z:5:5:-:cn=app,dc=example,dc=com:0:0:0:1:ip=10.0.0.1",
+			"65:20240131115000Z:9:6:-:cn=app,dc=example,dc=com:0:0:0:1:ip=10.0.0.2",
+			"66:20240131115900Z:1:0:-:cn=Directory Manager:0:0:0:1:ip=127.0.0.1",
+		),
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"ds_exporter_connections_by_bind_dn", map[string]string{"bind_dn": "cn=app,dc=example,dc=com"}, 2},
+		{"ds_exporter_connections_by_bind_dn", map[string]string{"bind_dn": otherBindDN}, 1},
+		{"ds_exporter_connection_oldest_age_seconds", nil, 3600},
+		{"ds_exporter_connection_ops_pending_max", nil, 3},
+	}
+	for _, tt := range tests {
+		if got, ok := gatherValue(t, c, tt.name, tt.labels); !ok || got != tt.want {
+			t.Errorf("%s%v = %v (present %v), want %v", tt.name, tt.labels, got, ok, tt.want)
+		}
+	}
+
+	count, sum, ok := gatherHistogram(t, c, "ds_exporter_connection_ops_pending", nil)
+	if !ok || count != 3 || sum != 4 {
+		t.Errorf("ops pending histogram count/sum = %d/%v (present %v), want 3/4", count, sum, ok)
+	}
+}
+
+func TestConnectionLabelSettings_AllowList(t *testing.T) {
+	s := connectionLabelSettings{BindDNs: []string{"CN=App,dc=example,dc=com"}}
+	got := s.limit(map[string]float64{
+		"cn=app,dc=example,dc=com":   3,
+		"cn=other,dc=example,dc=com": 2,
+		anonymousBindDN:              1,
+	})
+	if len(got) != 2 || got["cn=app,dc=example,dc=com"] != 3 || got[otherBindDN] != 3 {
+		t.Errorf("limit = %v", got)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// discoverySettings configures the opt-in discovery mode, which exports
+// every numeric attribute found under DNs instead of a fixed list.
+//
+//	discovery:
+//	  enabled: true
+//	  dns: [cn=monitor]
+//	  types:
+//	    threads: gauge
+//	    nsslapd-db-txn-region-wait-rate: counter
+type discoverySettings struct {
+	Enabled bool              `yaml:"enabled"`
+	DNs     []string          `yaml:"dns"`
+	Types   map[string]string `yaml:"types"`
+}
+
+// discoveryDefaultDNs are walked when no dns are configured.
+var discoveryDefaultDNs = []string{"cn=monitor", ldbmMonitorDN}
+
+// discovery holds the active settings; nil when discovery is disabled.
+var discovery *discoverySettings
+
+// discoverers are the discovery collectors of the /metrics targets, listed
+// by /debug/discovery.
+var discoverers []*discoveryCollector
+
+// Naming conventions used to guess the type of a discovered attribute.
+// Counter suffixes are checked first so that e.g. maxthreadsperconnhits is a
+// counter despite its max prefix. The LMDB transaction attributes share a txn
+// suffix, so they are told apart by prefix: activerwtxn is a gauge,
+// commitrwtxn a counter.
+var (
+	counterPrefixes = []string{"abort", "commit", "granttime", "lifetime"}
+	counterSuffixes = []string{"hits", "tries", "misses", "ops", "binds", "errors", "sent", "recv", "returned",
+		"seq", "evict", "evictions", "initiated", "completed", "rate", "pagein", "pageout", "conflicts", "txnid"}
+	gaugePrefixes = []string{"current", "max", "active", "waiting", "num"}
+	gaugeSuffixes = []string{"ratio", "size", "count", "locks", "readers", "pages"}
+)
+
+// validate fills in the default DNs and checks the type overrides.
+func (s *discoverySettings) validate() error {
+	if len(s.DNs) == 0 {
+		s.DNs = discoveryDefaultDNs
+	}
+	types := make(map[string]string, len(s.Types))
+	for attr, t := range s.Types {
+		if t != "counter" && t != "gauge" {
+			return fmt.Errorf("discovery type of %s must be counter or gauge, got %q", attr, t)
+		}
+		types[strings.ToLower(attr)] = t
+	}
+	s.Types = types
+	return nil
+}
+
+// kind returns the type of attr: the configured override, else a guess by
+// naming convention. known is false when neither applied and the attribute
+// defaults to a gauge.
+func (s *discoverySettings) kind(attr string) (k metricKind, known bool) {
+	attr = strings.ToLower(attr)
+	switch s.Types[attr] {
+	case "counter":
+		return counterKind, true
+	case "gauge":
+		return gaugeKind, true
+	}
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(attr, suffix) {
+			return counterKind, true
+		}
+	}
+	for _, prefix := range gaugePrefixes {
+		if strings.HasPrefix(attr, prefix) {
+			return gaugeKind, true
+		}
+	}
+	for _, prefix := range counterPrefixes {
+		if strings.HasPrefix(attr, prefix) {
+			return counterKind, true
+		}
+	}
+	for _, suffix := range gaugeSuffixes {
+		if strings.HasSuffix(attr, suffix) {
+			return gaugeKind, true
+		}
+	}
+	return gaugeKind, false
+}
+
+var nonNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// discoveredName builds the metric name of attr of the entry dn from the
+// RDN values, root first: bytessent of cn=snmp,cn=monitor becomes
+// ds_exporter_auto_monitor_snmp_bytessent.
+func discoveredName(dn, attr string) string {
+	var parts []string
+	if parsed, err := ldap.ParseDN(dn); err == nil {
+		for i := len(parsed.RDNs) - 1; i >= 0; i-- {
+			for _, a := range parsed.RDNs[i].Attributes {
+				parts = append(parts, a.Value)
+			}
+		}
+	} else {
+		parts = append(parts, dn)
+	}
+	parts = append(parts, attr)
+
+	name := nonNameChars.ReplaceAllString(strings.ToLower(strings.Join(parts, "_")), "_")
+	return prometheus.BuildFQName(namespace, "auto", strings.Trim(name, "_"))
+}
+
+// reportedAttr is an attribute listed by /debug/discovery: either not
+// exported because its value is not numeric, or exported with a type that
+// was neither configured nor guessed. Values are not kept: monitor entries
+// hold client addresses and bind DNs.
+type reportedAttr struct {
+	DN   string
+	Attr string
+	Kind string
+}
+
+// discoveryCollector exports every numeric attribute under the configured
+// DNs of one target. Its metrics are not known in advance, so it describes
+// none and is registered as an unchecked collector.
+type discoveryCollector struct {
+	e        *Exporter
+	settings *discoverySettings
+
+	mu      sync.Mutex
+	lastRun time.Time
+	lastErr error
+	report  []reportedAttr
+}
+
+func newDiscoveryCollector(e *Exporter, settings *discoverySettings) *discoveryCollector {
+	return &discoveryCollector{e: e, settings: settings}
+}
+
+func (c *discoveryCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *discoveryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+// CollectContext is Collect with the LDAP searches bounded by ctx.
+func (c *discoveryCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	report, err := c.collect(ctx, ch)
+	if err != nil {
+		log.Printf("Error discovering monitor attributes of %s: %v", c.e.url, err)
+	}
+
+	c.mu.Lock()
+	c.lastRun, c.lastErr, c.report = time.Now(), err, report
+	c.mu.Unlock()
+}
+
+func (c *discoveryCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) ([]reportedAttr, error) {
+	conn, err := c.e.getLDAPConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var report []reportedAttr
+	var firstErr, connErr error
+	seen := make(map[string]bool)
+	for _, base := range c.settings.DNs {
+		entries, err := searchSubtree(ctx, conn, base, nil, c.e.mod.Timeout)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if isCancelled(err) {
+				connErr = err
+				break
+			}
+			continue
+		}
+		for _, entry := range entries {
+			dn := strings.ToLower(entry.DN)
+			for _, attr := range entry.Attributes {
+				if len(attr.Values) == 0 || strings.EqualFold(attr.Name, "objectclass") {
+					continue
+				}
+				v, err := strconv.ParseFloat(attr.Values[0], 64)
+				if err != nil {
+					report = append(report, reportedAttr{DN: dn, Attr: attr.Name, Kind: "string, not exported"})
+					continue
+				}
+
+				name := discoveredName(dn, attr.Name)
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+
+				kind, known := c.settings.kind(attr.Name)
+				if !known {
+					report = append(report, reportedAttr{DN: dn, Attr: attr.Name, Kind: "gauge, untyped"})
+				}
+				desc := prometheus.NewDesc(name, fmt.Sprintf("Discovered attribute %s of %s", strings.ToLower(attr.Name), dn), nil, nil)
+				ch <- prometheus.MustNewConstMetric(desc, kind.valueType(), v)
+			}
+		}
+	}
+	c.e.releaseLDAPConn(conn, connErr)
+	return report, firstErr
+}
+
+// writeReport prints the attributes of the last scrape that were not
+// exported or exported with a default type.
+func (c *discoveryCollector) writeReport(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# target %s\n", c.e.url)
+	if c.lastRun.IsZero() {
+		fmt.Fprintln(w, "# not scraped yet")
+		return
+	}
+	fmt.Fprintf(w, "# last scrape %s\n", c.lastRun.Format(time.RFC3339))
+	if c.lastErr != nil {
+		fmt.Fprintf(w, "# error: %v\n", c.lastErr)
+	}
+	report := append([]reportedAttr(nil), c.report...)
+	sort.SliceStable(report, func(i, j int) bool { return report[i].DN < report[j].DN })
+	for _, s := range report {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.DN, s.Attr, s.Kind)
+	}
+}
+
+// discoveryDebugHandler serves /debug/discovery.
+func discoveryDebugHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if discovery == nil {
+		_, _ = io.WriteString(w, "# discovery mode is disabled\n")
+		return
+	}
+	for _, d := range discoverers {
+		d.writeReport(w)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestDiscoverySettings_Kind(t *testing.T) {
+	s := &discoverySettings{Types: map[string]string{"Threads": "counter"}}
+	if err := s.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		attr      string
+		want      metricKind
+		wantKnown bool
+	}{
+		{"threads", counterKind, true},
+		{"cachehits", counterKind, true},
+		{"maxthreadsperconnhits", counterKind, true},
+		{"nsslapd-db-page-read-rate", counterKind, true},
+		{"currentconnections", gaugeKind, true},
+		{"dbcachehitratio", gaugeKind, true},
+		{"dtablesize", gaugeKind, true},
+		{"activerwtxn", gaugeKind, true},
+		{"waitingrwtxn", gaugeKind, true},
+		{"activerotxn", gaugeKind, true},
+		{"waitingrotxn", gaugeKind, true},
+		{"commitrwtxn", counterKind, true},
+		{"lifetimerotxn", counterKind, true},
+		{"dbenvlasttxnid", counterKind, true},
+		{"nbackends", gaugeKind, false},
+	}
+	for _, tt := range tests {
+		got, known := s.kind(tt.attr)
+		if got != tt.want || known != tt.wantKnown {
+			t.Errorf("kind(%s) = %v, %v; want %v, %v", tt.attr, got, known, tt.want, tt.wantKnown)
+		}
+	}
+}
+
+func TestDiscoverySettings_Validate(t *testing.T) {
+	s := &discoverySettings{}
+	if err := s.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.DNs) != len(discoveryDefaultDNs) {
+		t.Errorf("DNs = %v, want the defaults", s.DNs)
+	}
+
+	s = &discoverySettings{Types: map[string]string{"threads": "histogram"}}
+	if err := s.validate(); err == nil {
+		t.Error("expected invalid type to be rejected")
+	}
+}
+
+func TestDiscoveredName(t *testing.T) {
+	tests := []struct{ dn, attr, want string }{
+		{"cn=snmp,cn=monitor", "bytessent", "ds_exporter_auto_monitor_snmp_bytessent"},
+		{"cn=database," + ldbmMonitorDN, "nsslapd-db-current-locks",
+			"ds_exporter_auto_config_plugins_ldbm_database_monitor_database_nsslapd_db_current_locks"},
+	}
+	for _, tt := range tests {
+		if got := discoveredName(tt.dn, tt.attr); got != tt.want {
+			t.Errorf("discoveredName(%s, %s) = %s, want %s", tt.dn, tt.attr, got, tt.want)
+		}
+	}
+}
+
+func TestDiscoveryCollector(t *testing.T) {
+	settings := &discoverySettings{DNs: []string{"cn=monitor"}}
+	if err := settings.validate(); err != nil {
+		t.Fatal(err)
+	}
+	e := mockExporter(routeMock(map[string][]*ldap.Entry{
+		"cn=monitor": {
+			{DN: "cn=monitor", Attributes: []*ldap.EntryAttribute{
+				{Name: "objectClass", Values: []string{"top"}},
+				{Name: "version", Values: []string{"389-Directory/2.4.5"}},
+				{Name: "nbackends", Values: []string{"1"}},
+			}},
+			{DN: "cn=snmp,cn=monitor", Attributes: []*ldap.EntryAttribute{
+				{Name: "bytessent", Values: []string{"2048"}},
+			}},
+		},
+	}))
+	d := newDiscoveryCollector(e, settings)
+
+	if v, ok := gatherValue(t, d, "ds_exporter_auto_monitor_snmp_bytessent", nil); !ok || v != 2048 {
+		t.Errorf("bytessent = %v (present %v), want 2048", v, ok)
+	}
+	if v, ok := gatherValue(t, d, "ds_exporter_auto_monitor_nbackends", nil); !ok || v != 1 {
+		t.Errorf("nbackends = %v (present %v), want 1", v, ok)
+	}
+
+	discovery, discoverers = settings, []*discoveryCollector{d}
+	t.Cleanup(func() { discovery, discoverers = nil, nil })
+	rec := httptest.NewRecorder()
+	discoveryDebugHandler(rec, httptest.NewRequest("GET", "/debug/discovery", nil))
+	body := rec.Body.String()
+	for _, want := range []string{"cn=monitor\tversion\tstring", "cn=monitor\tnbackends\tgauge, untyped"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("report missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "389-Directory") {
+		t.Errorf("report shows attribute values:\n%s", body)
+	}
+	if strings.Contains(body, "objectClass") || strings.Contains(body, "bytessent") {
+		t.Errorf("report lists attributes it should not:\n%s", body)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// diskSpaceDN is the monitor entry with one dsDisk value per partition
+// holding server files.
+const diskSpaceDN = "cn=disk space,cn=monitor"
+
+// configDN holds the disk monitoring settings.
+const configDN = "cn=config"
+
+var diskMonitoringAttrs = []string{
+	"nsslapd-disk-monitoring",
+	"nsslapd-disk-monitoring-threshold",
+	"nsslapd-disk-monitoring-grace-period",
+	"nsslapd-disk-monitoring-readonly-on-threshold",
+	"nsslapd-disk-monitoring-logging-critical",
+}
+
+// diskFieldRe matches the key="value" pairs of a dsDisk value, e.g.
+// partition="/" size="52576092160" used="11721695232" available="40854396928" use%="22".
+var diskFieldRe = regexp.MustCompile(`([\w%]+)="([^"]*)"`)
+
+// diskUsage is one parsed dsDisk value.
+type diskUsage struct {
+	Partition string
+	Size      float64
+	Used      float64
+	Available float64
+}
+
+// parseDSDisk decodes a dsDisk value. It fails if the partition or any of
+// the byte counts is missing.
+func parseDSDisk(v string) (diskUsage, bool) {
+	fields := make(map[string]string)
+	for _, m := range diskFieldRe.FindAllStringSubmatch(v, -1) {
+		fields[strings.ToLower(m[1])] = m[2]
+	}
+	d := diskUsage{Partition: fields["partition"]}
+	if d.Partition == "" {
+		return diskUsage{}, false
+	}
+	for key, dst := range map[string]*float64{"size": &d.Size, "used": &d.Used, "available": &d.Available} {
+		n, err := strconv.ParseFloat(fields[key], 64)
+		if err != nil {
+			return diskUsage{}, false
+		}
+		*dst = n
+	}
+	return d, true
+}
+
+// diskCollector exports the space of every partition the server watches,
+// and the nsslapd-disk-monitoring settings that decide when the server
+// turns read-only or shuts down as free space runs out.
+type diskCollector struct {
+	sizeDesc      *prometheus.Desc
+	usedDesc      *prometheus.Desc
+	availableDesc *prometheus.Desc
+
+	enabledDesc         *prometheus.Desc
+	thresholdDesc       *prometheus.Desc
+	gracePeriodDesc     *prometheus.Desc
+	readOnlyDesc        *prometheus.Desc
+	loggingCriticalDesc *prometheus.Desc
+}
+
+func newDiskCollector() *diskCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+	}
+	return &diskCollector{
+		sizeDesc:      desc("disk_size_bytes", "Size of the partition, from dsDisk of cn=disk space,cn=monitor", "partition"),
+		usedDesc:      desc("disk_used_bytes", "Used space of the partition, from dsDisk of cn=disk space,cn=monitor", "partition"),
+		availableDesc: desc("disk_available_bytes", "Space available on the partition, from dsDisk of cn=disk space,cn=monitor", "partition"),
+
+		enabledDesc:         desc("disk_monitoring_enabled", "Whether nsslapd-disk-monitoring is on (1) or off (0)"),
+		thresholdDesc:       desc("disk_monitoring_threshold_bytes", "Free space below which the server starts protecting itself, from nsslapd-disk-monitoring-threshold"),
+		gracePeriodDesc:     desc("disk_monitoring_grace_period_seconds", "Time the server waits below half the threshold before shutting down, from nsslapd-disk-monitoring-grace-period"),
+		readOnlyDesc:        desc("disk_monitoring_readonly_on_threshold", "Whether the server turns read-only below the threshold (1) or not (0)"),
+		loggingCriticalDesc: desc("disk_monitoring_logging_critical", "Whether access logging stays on below the threshold (1) or is disabled (0)"),
+	}
+}
+
+func (c *diskCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.sizeDesc
+	ch <- c.usedDesc
+	ch <- c.availableDesc
+	ch <- c.enabledDesc
+	ch <- c.thresholdDesc
+	ch <- c.gracePeriodDesc
+	ch <- c.readOnlyDesc
+	ch <- c.loggingCriticalDesc
+}
+
+func (c *diskCollector) collect(ctx context.Context, conn LDAPClient, timeout time.Duration, monitor []*ldap.Entry, ch chan<- prometheus.Metric) error {
+	seen := make(map[string]bool)
+	for _, e := range monitor {
+		if !strings.EqualFold(e.DN, diskSpaceDN) {
+			continue
+		}
+		for _, v := range e.GetEqualFoldAttributeValues("dsdisk") {
+			d, ok := parseDSDisk(v)
+			if !ok || seen[d.Partition] {
+				continue
+			}
+			seen[d.Partition] = true
+			ch <- prometheus.MustNewConstMetric(c.sizeDesc, prometheus.GaugeValue, d.Size, d.Partition)
+			ch <- prometheus.MustNewConstMetric(c.usedDesc, prometheus.GaugeValue, d.Used, d.Partition)
+			ch <- prometheus.MustNewConstMetric(c.availableDesc, prometheus.GaugeValue, d.Available, d.Partition)
+		}
+	}
+
+	// cn=config is hidden from unprivileged binds: no entry means nothing
+	// to export rather than an error.
+	entries, err := searchScope(ctx, conn, configDN, ldap.ScopeBaseObject, "(objectclass=*)", diskMonitoringAttrs, timeout)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	cfg := entries[0]
+	for attr, desc := range map[string]*prometheus.Desc{
+		"nsslapd-disk-monitoring":                       c.enabledDesc,
+		"nsslapd-disk-monitoring-readonly-on-threshold": c.readOnlyDesc,
+		"nsslapd-disk-monitoring-logging-critical":      c.loggingCriticalDesc,
+	} {
+		if v := cfg.GetEqualFoldAttributeValue(attr); v != "" {
+			on := 0.0
+			if strings.EqualFold(v, "on") {
+				on = 1
+			}
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, on)
+		}
+	}
+	if n, err := strconv.ParseFloat(cfg.GetEqualFoldAttributeValue("nsslapd-disk-monitoring-threshold"), 64); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.thresholdDesc, prometheus.GaugeValue, n)
+	}
+	// The grace period is configured in minutes.
+	if n, err := strconv.ParseFloat(cfg.GetEqualFoldAttributeValue("nsslapd-disk-monitoring-grace-period"), 64); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.gracePeriodDesc, prometheus.GaugeValue, n*60)
+	}
+	return nil
+}
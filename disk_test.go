@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestParseDSDisk(t *testing.T) {
+	d, ok := parseDSDisk(`partition="/var/lib/dirsrv" size="52576092160" used="11721695232" available="40854396928" use%="22"`)
+	if !ok {
+		t.Fatal("failed to parse a valid dsDisk value")
+	}
+	want := diskUsage{Partition: "/var/lib/dirsrv", Size: 52576092160, Used: 11721695232, Available: 40854396928}
+	if d != want {
+		t.Errorf("parsed %+v, want %+v", d, want)
+	}
+
+	for _, bad := range []string{
+		``,
+		`size="1" used="1" available="0"`,
+		`partition="/" size="1" used="x" available="0"`,
+		`partition="/" size="1" used="1"`,
+	} {
+		if _, ok := parseDSDisk(bad); ok {
+			t.Errorf("%q: expected a parse failure", bad)
+		}
+	}
+}
+
+func TestDiskCollector(t *testing.T) {
+	monitor := monitorTestEntries(func(int) string { return "1" })
+	monitor = append(monitor, &ldap.Entry{
+		DN: "cn=disk space,cn=monitor",
+		Attributes: []*ldap.EntryAttribute{{Name: "dsDisk", Values: []string{
+			`partition="/" size="1000" used="600" available="400" use%="60"`,
+			`partition="/var/log/dirsrv" size="2000" used="100" available="1900" use%="5"`,
+		}}},
+	})
+	e := mockExporter(routeMock(map[string][]*ldap.Entry{
+		"cn=monitor": monitor,
+		"cn=config": {{
+			DN: "cn=config",
+			Attributes: []*ldap.EntryAttribute{
+				{Name: "nsslapd-disk-monitoring", Values: []string{"on"}},
+				{Name: "nsslapd-disk-monitoring-threshold", Values: []string{"2097152"}},
+				{Name: "nsslapd-disk-monitoring-grace-period", Values: []string{"60"}},
+				{Name: "nsslapd-disk-monitoring-readonly-on-threshold", Values: []string{"off"}},
+			},
+		}},
+	}))
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"ds_exporter_disk_size_bytes", map[string]string{"partition": "/"}, 1000},
+		{"ds_exporter_disk_used_bytes", map[string]string{"partition": "/"}, 600},
+		{"ds_exporter_disk_available_bytes", map[string]string{"partition": "/var/log/dirsrv"}, 1900},
+		{"ds_exporter_disk_monitoring_enabled", nil, 1},
+		{"ds_exporter_disk_monitoring_threshold_bytes", nil, 2097152},
+		{"ds_exporter_disk_monitoring_grace_period_seconds", nil, 3600},
+		{"ds_exporter_disk_monitoring_readonly_on_threshold", nil, 0},
+	}
+	for _, tt := range tests {
+		if v, ok := gatherValue(t, e, tt.name, tt.labels); !ok || v != tt.want {
+			t.Errorf("%s%v = %v (present %v), want %v", tt.name, tt.labels, v, ok, tt.want)
+		}
+	}
+	if _, ok := gatherValue(t, e, "ds_exporter_disk_monitoring_logging_critical", nil); ok {
+		t.Error("an unset setting should not be exported")
+	}
+}
+
+func TestDiskCollector_ConfigHidden(t *testing.T) {
+	e := mockExporter(routeMock(map[string][]*ldap.Entry{
+		"cn=monitor": monitorTestEntries(func(int) string { return "1" }),
+	}))
+
+	if v, _ := gatherValue(t, e, "ds_exporter_last_scrape_error", nil); v != 0 {
+		t.Errorf("last_scrape_error = %v, an unreadable cn=config is not an error", v)
+	}
+	if _, ok := gatherValue(t, e, "ds_exporter_disk_monitoring_enabled", nil); ok {
+		t.Error("disk monitoring settings exported without cn=config")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errorsLogTimeLayout is the timestamp of errors log lines. Parsing accepts
+// the fractional seconds 389-DS writes after the seconds field.
+const errorsLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// errorsLogLineRe matches the lines of the errors log, e.g.
+//
+//	[31/Jan/2024:12:00:00.123456789 +0000] - ERR - NSMMReplicationPlugin - bind_and_check_pwp - agmt="cn=to-ds2" (ds2:389) - Replication bind failed
+//
+// The subsystem may be empty. Continuation lines of multi-line messages do
+// not match and are ignored.
+var errorsLogLineRe = regexp.MustCompile(`^\[([^\]]+)\] - ([A-Z]+) - (.*?) - (.*)$`)
+
+// errorsLogAlertSeverities are the severities that update the last error
+// timestamp.
+var errorsLogAlertSeverities = map[string]bool{
+	"EMERG": true,
+	"ALERT": true,
+	"CRIT":  true,
+	"ERR":   true,
+}
+
+// errorsLogSettings is the errors_log section of --config.file.
+//
+//	errors_log:
+//	  rules:
+//	    - name: replication_bind_failed
+//	      match: Replication bind .* failed
+type errorsLogSettings struct {
+	Rules []errorsLogRuleConfig `yaml:"rules"`
+}
+
+// errorsLogRuleConfig counts the errors log lines whose message matches a
+// regular expression under a name.
+type errorsLogRuleConfig struct {
+	Name  string `yaml:"name"`
+	Match string `yaml:"match"`
+}
+
+type errorsLogRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// errorsLogRules holds the rules declared in --config.file.
+var errorsLogRules []errorsLogRule
+
+// buildErrorsLogRules validates the rule declarations.
+func buildErrorsLogRules(cfgs []errorsLogRuleConfig) ([]errorsLogRule, error) {
+	seen := make(map[string]bool)
+	var rules []errorsLogRule
+	for i, rc := range cfgs {
+		if rc.Name == "" {
+			return nil, fmt.Errorf("errors_log rule #%d: name is required", i+1)
+		}
+		if seen[rc.Name] {
+			return nil, fmt.Errorf("errors_log rule %q: duplicate name", rc.Name)
+		}
+		seen[rc.Name] = true
+		if rc.Match == "" {
+			return nil, fmt.Errorf("errors_log rule %q: match is required", rc.Name)
+		}
+		re, err := regexp.Compile(rc.Match)
+		if err != nil {
+			return nil, fmt.Errorf("errors_log rule %q: invalid match: %v", rc.Name, err)
+		}
+		rules = append(rules, errorsLogRule{name: rc.Name, re: re})
+	}
+	return rules, nil
+}
+
+// errorsLogCollector counts the lines of the errors log by severity and
+// subsystem, and the messages matching the configured rules.
+type errorsLogCollector struct {
+	rules []errorsLogRule
+
+	mu        sync.Mutex
+	lastError time.Time
+
+	lines         *prometheus.CounterVec
+	matches       *prometheus.CounterVec
+	lastErrorDesc *prometheus.Desc
+}
+
+func newErrorsLogCollector(rules []errorsLogRule) *errorsLogCollector {
+	c := &errorsLogCollector{
+		rules: rules,
+		lines: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "errors_log_lines_total",
+			Help:      "Lines of the errors log by severity and subsystem",
+		}, []string{"severity", "subsystem"}),
+		matches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "errors_log_matches_total",
+			Help:      "Lines of the errors log whose message matched a configured rule",
+		}, []string{"rule"}),
+		lastErrorDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "errors_log_last_error_timestamp_seconds"),
+			"Time of the last ERR, CRIT, ALERT or EMERG line of the errors log",
+			nil, nil,
+		),
+	}
+	// Rules are reported from the start so that increase() sees their
+	// first match.
+	for _, r := range rules {
+		c.matches.WithLabelValues(r.name)
+	}
+	return c
+}
+
+func (c *errorsLogCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.lines.Describe(ch)
+	c.matches.Describe(ch)
+	ch <- c.lastErrorDesc
+}
+
+func (c *errorsLogCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lines.Collect(ch)
+	c.matches.Collect(ch)
+
+	c.mu.Lock()
+	lastError := c.lastError
+	c.mu.Unlock()
+	if !lastError.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.lastErrorDesc, prometheus.GaugeValue, float64(lastError.UnixNano())/1e9)
+	}
+}
+
+// handleLine processes one errors log line.
+func (c *errorsLogCollector) handleLine(line string) {
+	m := errorsLogLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	severity, subsystem, message := m[2], strings.TrimSpace(m[3]), m[4]
+
+	c.lines.WithLabelValues(severity, subsystem).Inc()
+	for _, r := range c.rules {
+		if r.re.MatchString(message) {
+			c.matches.WithLabelValues(r.name).Inc()
+		}
+	}
+
+	if !errorsLogAlertSeverities[severity] {
+		return
+	}
+	ts, err := time.Parse(errorsLogTimeLayout, m[1])
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	if ts.After(c.lastError) {
+		c.lastError = ts
+	}
+	c.mu.Unlock()
+}
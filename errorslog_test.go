@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorsLogCollector(t *testing.T) {
+	rules, err := buildErrorsLogRules([]errorsLogRuleConfig{
+		{Name: "replication_bind_failed", Match: `Replication bind .* failed`},
+		{Name: "disk_full", Match: `disk space is (low|critically low)`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newErrorsLogCollector(rules)
+	for _, line := range []string{
+		`[31/Jan/2024:12:00:00.100000000 +0000] - NOTICE - main - 389-Directory/2.4.5 B2024.031.0000 starting up`,
+		`[31/Jan/2024:12:00:01.250000000 +0000] - ERR - NSMMReplicationPlugin - bind_and_check_pwp - agmt="cn=to-ds2" (ds2:389) - Replication bind with SIMPLE auth failed: LDAP error 49 (Invalid credentials) ()`,
+		`[31/Jan/2024:12:00:02.000000000 +0000] - WARN - NSMMReplicationPlugin - send_updates - agmt="cn=to-ds2" (ds2:389) - Consumer busy`,
+		`[31/Jan/2024:11:59:00.000000000 +0000] - CRIT - ldbm_back_start - Out of memory`,
+		`[31/Jan/2024:12:00:03.000000000 +0000] - INFO -  - continued message without subsystem`,
+		`    a continuation line`,
+	} {
+		c.handleLine(line)
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"ds_exporter_errors_log_lines_total", map[string]string{"severity": "NOTICE", "subsystem": "main"}, 1},
+		{"ds_exporter_errors_log_lines_total", map[string]string{"severity": "ERR", "subsystem": "NSMMReplicationPlugin"}, 1},
+		{"ds_exporter_errors_log_lines_total", map[string]string{"severity": "WARN", "subsystem": "NSMMReplicationPlugin"}, 1},
+		{"ds_exporter_errors_log_lines_total", map[string]string{"severity": "CRIT", "subsystem": "ldbm_back_start"}, 1},
+		{"ds_exporter_errors_log_lines_total", map[string]string{"severity": "INFO", "subsystem": ""}, 1},
+		{"ds_exporter_errors_log_matches_total", map[string]string{"rule": "replication_bind_failed"}, 1},
+		{"ds_exporter_errors_log_matches_total", map[string]string{"rule": "disk_full"}, 0},
+		// The earlier CRIT line does not move the timestamp back.
+		{"ds_exporter_errors_log_last_error_timestamp_seconds", nil, float64(time.Date(2024, 1, 31, 12, 0, 1, 250000000, time.UTC).UnixNano()) / 1e9},
+	}
+	for _, tt := range tests {
+		got, ok := gatherValue(t, c, tt.name, tt.labels)
+		if !ok || got != tt.want {
+			t.Errorf("%s%v = %v (present %v), want %v", tt.name, tt.labels, got, ok, tt.want)
+		}
+	}
+}
+
+func TestErrorsLogCollector_NoErrorYet(t *testing.T) {
+	c := newErrorsLogCollector(nil)
+	c.handleLine(`[31/Jan/2024:12:00:00.100000000 +0000] - INFO - main - starting up`)
+	if _, ok := gatherValue(t, c, "ds_exporter_errors_log_last_error_timestamp_seconds", nil); ok {
+		t.Error("last error timestamp should be absent before any error")
+	}
+}
+
+func TestBuildErrorsLogRules_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []errorsLogRuleConfig
+		want  string
+	}{
+		{"no name", []errorsLogRuleConfig{{Match: "x"}}, "rule #1: name is required"},
+		{"no match", []errorsLogRuleConfig{{Name: "a"}}, "match is required"},
+		{"bad match", []errorsLogRuleConfig{{Name: "a", Match: "("}}, "invalid match"},
+		{"duplicate", []errorsLogRuleConfig{{Name: "a", Match: "x"}, {Name: "a", Match: "y"}}, "duplicate name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildErrorsLogRules(tt.rules)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"reflect"
 	"sync"
 	"time"
 
@@ -12,71 +12,11 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-type metricKind int
-
-const (
-	gaugeKind metricKind = iota
-	counterKind
-)
-
-type metricDef struct {
-	ldapName string
-	fieldIdx int
-	help     string
-	kind     metricKind
-	label    string
-}
-
-// metricDefs is the single source of truth for all 33 metrics.
-// fieldIdx must match obj.DSData struct field order.
-var metricDefs = []metricDef{
-	{ldapName: "threads", fieldIdx: 0, help: "Number of Threads max configured", kind: gaugeKind, label: "threads"},
-	{ldapName: "readwaiters", fieldIdx: 1, help: "Current number of threads waiting to read data from a client", kind: gaugeKind, label: "readwaiters"},
-	{ldapName: "opsinitiated", fieldIdx: 2, help: "Current number of operations the server has initiated since it started", kind: counterKind, label: "opsinitiated"},
-	{ldapName: "opscompleted", fieldIdx: 3, help: "Current number of operations the server has completed since it started", kind: counterKind, label: "opscompleted"},
-	{ldapName: "dtablesize", fieldIdx: 4, help: "The number of file descriptors available to the directory. Essentially, this value shows how many additional concurrent connections can be serviced by the directory", kind: gaugeKind, label: "dtablesize"},
-	{ldapName: "anonymousbinds", fieldIdx: 5, help: "Number of Anonymous Binds", kind: counterKind, label: "anonymousbinds"},
-	{ldapName: "unauthbinds", fieldIdx: 6, help: "Number of Unauth Binds", kind: counterKind, label: "unauthbinds"},
-	{ldapName: "simpleauthbinds", fieldIdx: 7, help: "Number of Simple Auth Binds", kind: counterKind, label: "simpleauthbinds"},
-	{ldapName: "strongauthbinds", fieldIdx: 8, help: "Number of Strong Auth Binds", kind: counterKind, label: "strongauthbinds"},
-	{ldapName: "bindsecurityerrors", fieldIdx: 9, help: "Number of Bind Security Errors", kind: counterKind, label: "bindsecurityerrors"},
-	{ldapName: "inops", fieldIdx: 10, help: "Number of All Requests", kind: counterKind, label: "inops"},
-	{ldapName: "readops", fieldIdx: 11, help: "Number of Read Operations", kind: counterKind, label: "readops"},
-	{ldapName: "compareops", fieldIdx: 12, help: "Number of Compare Operations", kind: counterKind, label: "compareops"},
-	{ldapName: "addentryops", fieldIdx: 13, help: "Number of Add Entry Operations", kind: counterKind, label: "addentryops"},
-	{ldapName: "removeentryops", fieldIdx: 14, help: "Number of Remove Entry Operations", kind: counterKind, label: "removeentryops"},
-	{ldapName: "modifyentryops", fieldIdx: 15, help: "Number of Modify Entry Operations", kind: counterKind, label: "modifyentryops"},
-	{ldapName: "modifyrdnops", fieldIdx: 16, help: "Number of Modify RDN Operations", kind: counterKind, label: "modifyrdnops"},
-	{ldapName: "searchops", fieldIdx: 17, help: "Number of LDAP Search Requests", kind: counterKind, label: "searchops"},
-	{ldapName: "onelevelsearchops", fieldIdx: 18, help: "Number of one-level Search Requests", kind: counterKind, label: "onelevelsearchops"},
-	{ldapName: "wholesubtreesearchops", fieldIdx: 19, help: "Number of subtree-level Search Requests", kind: counterKind, label: "wholesubtreesearchops"},
-	{ldapName: "referrals", fieldIdx: 20, help: "Number of LDAP referrals", kind: counterKind, label: "referrals"},
-	{ldapName: "securityerrors", fieldIdx: 21, help: "Number of Security Errors", kind: counterKind, label: "securityerrors"},
-	{ldapName: "errors", fieldIdx: 22, help: "Number of Errors", kind: counterKind, label: "errors"},
-	{ldapName: "connections", fieldIdx: 23, help: "Number of Connections in Open State at the sampling time", kind: gaugeKind, label: "connections"},
-	{ldapName: "connectionseq", fieldIdx: 24, help: "Total Number of Connections opened", kind: counterKind, label: "connectionseq"},
-	{ldapName: "connectionsinmaxthreads", fieldIdx: 25, help: "Number of connections that are currently in a max thread state", kind: gaugeKind, label: "connectionsinmaxthreads"},
-	{ldapName: "connectionsmaxthreadscount", fieldIdx: 26, help: "Number of connectionsmaxthreadscount", kind: gaugeKind, label: "connectionsmaxthreadscount"},
-	{ldapName: "bytesrecv", fieldIdx: 27, help: "Total number of bytes received", kind: counterKind, label: "bytesrecv"},
-	{ldapName: "bytessent", fieldIdx: 28, help: "Total number of bytes sent", kind: counterKind, label: "bytessent"},
-	{ldapName: "entriesreturned", fieldIdx: 29, help: "Number of Entries Returned", kind: counterKind, label: "entriesreturned"},
-	{ldapName: "referralsreturned", fieldIdx: 30, help: "Number of Referrals Returned", kind: counterKind, label: "referralsreturned"},
-	{ldapName: "cacheentries", fieldIdx: 31, help: "Number of Cache Entries", kind: gaugeKind, label: "cacheentries"},
-	{ldapName: "cachehits", fieldIdx: 32, help: "Number of Cache Hits", kind: counterKind, label: "cachehits"},
-}
-
-// ldapFieldMap maps LDAP attribute names to DSData field indices.
-var ldapFieldMap map[string]int
-
-func init() {
-	ldapFieldMap = make(map[string]int, len(metricDefs))
-	for _, m := range metricDefs {
-		ldapFieldMap[m.ldapName] = m.fieldIdx
-	}
-}
-
 type LDAPClient interface {
 	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Bind(username, password string) error
+	ExternalBind() error
+	Compare(dn, attribute, value string) (bool, error)
 	Close() error
 }
 
@@ -88,14 +28,31 @@ func (c *ldapClient) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
 	return c.conn.Search(req)
 }
 
+func (c *ldapClient) Bind(username, password string) error {
+	return c.conn.Bind(username, password)
+}
+
+func (c *ldapClient) ExternalBind() error {
+	return c.conn.ExternalBind()
+}
+
+func (c *ldapClient) Compare(dn, attribute, value string) (bool, error) {
+	return c.conn.Compare(dn, attribute, value)
+}
+
 func (c *ldapClient) Close() error {
 	return c.conn.Close()
 }
 
-type dialFunc func(string) (LDAPClient, error)
+// dialFunc connects to a directory server. ctx bounds the connect and any
+// TLS negotiation.
+type dialFunc func(ctx context.Context, addr string) (LDAPClient, error)
 
-func runWithTimeout[T any](timeout time.Duration, fn func() (T, error)) (T, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// runLDAP runs fn, an operation on conn, bounded by ctx and timeout. When
+// either expires first conn is closed, which fails the pending operation,
+// and fn is waited for so that no goroutine outlives the call.
+func runLDAP[T any](ctx context.Context, conn LDAPClient, timeout time.Duration, fn func() (T, error)) (T, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	type result struct {
@@ -112,118 +69,329 @@ func runWithTimeout[T any](timeout time.Duration, fn func() (T, error)) (T, erro
 	case r := <-resultCh:
 		return r.val, r.err
 	case <-ctx.Done():
+		_ = conn.Close()
+		<-resultCh
 		var zero T
 		return zero, ctx.Err()
 	}
 }
 
+// isCancelled reports whether err comes from an operation cut short by its
+// context, which closed the connection the operation ran on.
+func isCancelled(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// Failure stages reported by ds_exporter_scrape_failures_total.
+const (
+	stageDial   = "dial"
+	stageTLS    = "tls"
+	stageBind   = "bind"
+	stageSearch = "search"
+)
+
+var scrapeStages = []string{stageDial, stageTLS, stageBind, stageSearch}
+
+// connStage returns the stage at which getLDAPConn failed.
+func connStage(err error) string {
+	switch {
+	case errors.Is(err, errTLS):
+		return stageTLS
+	case errors.Is(err, errBind):
+		return stageBind
+	default:
+		return stageDial
+	}
+}
+
 // Exporter stores metrics from 389DS
 type Exporter struct {
-	mu       sync.Mutex
-	ldapConn LDAPClient
-	dial     dialFunc
-	descs    []*prometheus.Desc
+	mu   sync.Mutex
+	pool *connPool
+	dial dialFunc
+	url  string
+	mod  *module
+
+	scrapeMetrics
+
+	collectors []collector
+	// certs exports the certificates of the target's TLS listeners, whether
+	// or not the scrape itself succeeds; nil when no checks are configured.
+	certs *certCollector
+	// ruvs keeps the RUVs read by the last scrape, for the replication lag
+	// in polling mode.
+	ruvs *ruvCollector
 }
 
-// NewExporter returns an initialized exporter
-func NewExporter() *Exporter {
+// scrapeMetrics are the metrics every target reports about its scrape and
+// its connections, whatever the outcome.
+type scrapeMetrics struct {
+	upDesc        *prometheus.Desc
+	durationDesc  *prometheus.Desc
+	lastErrorDesc *prometheus.Desc
+	failures      *prometheus.CounterVec
+	breakerDesc   *prometheus.Desc
+	openedDesc    *prometheus.Desc
+	poolDesc      *prometheus.Desc
+}
+
+func newScrapeMetrics() scrapeMetrics {
+	m := scrapeMetrics{
+		upDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "up"),
+			"Whether the directory server could be reached and its monitor data read (1) or not (0)", nil, nil,
+		),
+		durationDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "scrape_duration_seconds"),
+			"Time taken to collect metrics from the directory server", nil, nil,
+		),
+		lastErrorDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "last_scrape_error"),
+			"Whether the last scrape of the directory server hit any error (1) or not (0)", nil, nil,
+		),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "scrape_failures_total",
+			Help:      "Number of failed scrapes by the stage that failed: dial, tls, bind or search",
+		}, []string{"stage"}),
+		breakerDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "circuit_breaker_state"),
+			"State of the circuit breaker in front of the directory server: closed, open or half_open", []string{"state"}, nil,
+		),
+		openedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "circuit_breaker_opened_total"),
+			"Number of times the circuit breaker opened after repeated connection failures", nil, nil,
+		),
+		poolDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "pool_connections"),
+			"Number of pooled connections to the directory server by state: idle or in_use", []string{"state"}, nil,
+		),
+	}
+	for _, stage := range scrapeStages {
+		m.failures.WithLabelValues(stage)
+	}
+	return m
+}
+
+func (m scrapeMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.upDesc
+	ch <- m.durationDesc
+	ch <- m.lastErrorDesc
+	m.failures.Describe(ch)
+	ch <- m.breakerDesc
+	ch <- m.openedDesc
+	ch <- m.poolDesc
+}
+
+// newTargetCollectors returns the collectors every target runs, whatever
+// the configuration file declares.
+func newTargetCollectors(ruvs *ruvCollector) []collector {
+	return []collector{
+		newMonitorCollector(),
+		newServerCollector(),
+		newBackendCollector(),
+		newLDBMCollector(),
+		newReplicationCollector(),
+		ruvs,
+		newConnectionCollector(connectionLabels),
+		newPluginCollector(),
+		newDiskCollector(),
+	}
+}
+
+// NewExporter returns an initialized exporter for the directory server at
+// url, connecting with the settings of mod.
+func NewExporter(url string, mod *module) *Exporter {
+	return newExporter(url, mod, syntheticProbes)
+}
+
+// newExporter is NewExporter running the given synthetic probes. Targets
+// not from the configuration run none, so the probe identities are never
+// sent to a host chosen by a /probe caller.
+func newExporter(url string, mod *module, probes []*syntheticProbe) *Exporter {
+	ruvs := newRUVCollector()
 	e := &Exporter{
-		descs: make([]*prometheus.Desc, len(metricDefs)),
-		dial: func(addr string) (LDAPClient, error) {
-			conn, err := ldap.DialURL(addr)
-			if err != nil {
-				return nil, err
-			}
-			return &ldapClient{conn: conn}, nil
+		url: url,
+		mod: mod,
+		dial: func(ctx context.Context, addr string) (LDAPClient, error) {
+			return dialLDAP(ctx, addr, mod)
 		},
+		scrapeMetrics: newScrapeMetrics(),
+		collectors:    newTargetCollectors(ruvs),
+		certs:         newCertCollector(url, mod),
+		ruvs:          ruvs,
 	}
-	for i, m := range metricDefs {
-		e.descs[i] = prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", m.label),
-			m.help, nil, nil,
-		)
+	if len(queries) > 0 {
+		e.collectors = append(e.collectors, newQueryCollector(queries))
+	}
+	if len(probes) > 0 {
+		e.collectors = append(e.collectors, newSyntheticCollector(probes, func(ctx context.Context) (LDAPClient, error) {
+			return e.dial(ctx, e.url)
+		}))
 	}
 	return e
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	for _, d := range e.descs {
-		ch <- d
+	e.scrapeMetrics.describe(ch)
+	for _, c := range e.collectors {
+		c.describe(ch)
+	}
+	if e.certs != nil {
+		e.certs.describe(ch)
 	}
 }
 
-func (e *Exporter) getLDAPConn() (LDAPClient, error) {
+// connections returns the connection pool of the exporter, creating it on
+// first use.
+func (e *Exporter) connections() *connPool {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	if e.ldapConn != nil {
-		return e.ldapConn, nil
+	if e.pool == nil {
+		e.pool = newConnPool(poolSize, e.mod.Timeout, e.dialAndBind)
 	}
+	return e.pool
+}
 
+// dialAndBind dials and binds a new connection within the module timeout.
+func (e *Exporter) dialAndBind(ctx context.Context) (LDAPClient, error) {
 	if e.dial == nil {
 		return nil, fmt.Errorf("no dial function configured")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ldapTimeout)
+	ctx, cancel := context.WithTimeout(ctx, e.mod.Timeout)
 	defer cancel()
 
-	resultCh := make(chan struct {
-		c   LDAPClient
-		err error
-	}, 1)
-
-	go func() {
-		c, err := e.dial(fmt.Sprintf("ldap://%s:%d", server, port))
-		resultCh <- struct {
-			c   LDAPClient
-			err error
-		}{c: c, err: err}
-	}()
-
-	select {
-	case result := <-resultCh:
-		if result.err != nil {
-			log.Printf("LDAP connection failed: %v", result.err)
-			return nil, result.err
+	c, err := e.dial(ctx, e.url)
+	if err == nil {
+		_, err = runLDAP(ctx, c, e.mod.Timeout, func() (struct{}, error) {
+			return struct{}{}, e.mod.bind(c)
+		})
+		if err != nil {
+			_ = c.Close()
 		}
-		e.ldapConn = result.c
-		return result.c, nil
-	case <-ctx.Done():
-		return nil, fmt.Errorf("LDAP connection timeout after %v to %s:%d", ldapTimeout, server, port)
 	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("LDAP connection timeout after %v to %s: %w", e.mod.Timeout, e.url, err)
+		}
+		log.Printf("LDAP connection failed: %v", err)
+		return nil, err
+	}
+	return c, nil
+}
+
+// getLDAPConn takes a connection from the pool. It must be handed back with
+// releaseLDAPConn.
+func (e *Exporter) getLDAPConn(ctx context.Context) (LDAPClient, error) {
+	return e.connections().get(ctx)
 }
 
+// releaseLDAPConn hands conn back to the pool. err is the error that broke
+// the connection, or nil if it can be reused.
+func (e *Exporter) releaseLDAPConn(conn LDAPClient, err error) {
+	e.connections().release(conn, err)
+}
+
+// closeLDAPConn closes the idle pooled connections.
 func (e *Exporter) closeLDAPConn() {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	e.connections().close()
+}
+
+// Collect reads stats from LDAP connection object into Prometheus objects.
+// The up, scrape duration and last error metrics are always emitted so a
+// failed scrape is distinguishable from a missing metric. A failing
+// additional collector sets last_scrape_error but leaves up at 1.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.CollectContext(context.Background(), ch)
+}
+
+// CollectContext is Collect with the LDAP operations bounded by ctx.
+func (e *Exporter) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	_, _ = e.collect(ctx, ch)
+}
+
+// collect is Collect returning the outcome of the scrape: whether the
+// cn=monitor data could be read, and the first error of any stage.
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) (bool, error) {
+	start := time.Now()
+	up, lastErr := 1.0, 0.0
+	ok, err := e.scrape(ctx, ch)
+	if !ok {
+		up = 0
+	}
+	if err != nil {
+		lastErr = 1
+	}
+	ch <- prometheus.MustNewConstMetric(e.upDesc, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(e.durationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
+	ch <- prometheus.MustNewConstMetric(e.lastErrorDesc, prometheus.GaugeValue, lastErr)
+	e.failures.Collect(ch)
 
-	if e.ldapConn != nil {
-		_ = e.ldapConn.Close()
-		e.ldapConn = nil
+	state, opened, idle, inUse := e.connections().stats()
+	for _, s := range breakerStates {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(e.breakerDesc, prometheus.GaugeValue, v, s.String())
 	}
+	ch <- prometheus.MustNewConstMetric(e.openedDesc, prometheus.CounterValue, float64(opened))
+	ch <- prometheus.MustNewConstMetric(e.poolDesc, prometheus.GaugeValue, float64(idle), "idle")
+	ch <- prometheus.MustNewConstMetric(e.poolDesc, prometheus.GaugeValue, float64(inUse), "in_use")
+
+	if e.certs != nil {
+		e.certs.collect(ctx, ch)
+	}
+	return ok, err
 }
 
-// Collect reads stats from LDAP connection object into Prometheus objects
-func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	conn, err := e.getLDAPConn()
+// scrape reports whether the cn=monitor data could be read, and the first
+// error of any stage.
+func (e *Exporter) scrape(ctx context.Context, ch chan<- prometheus.Metric) (bool, error) {
+	conn, err := e.getLDAPConn(ctx)
+	if errors.Is(err, errCircuitOpen) {
+		log.Printf("Skipping scrape of %s: %v", e.url, err)
+		return false, err
+	}
 	if err != nil {
-		log.Printf("Error getting LDAP connection: %v", err)
-		return
+		stage := connStage(err)
+		switch stage {
+		case stageTLS:
+			log.Printf("Error negotiating TLS with LDAP: %v", err)
+		case stageBind:
+			log.Printf("Error binding to LDAP: %v", err)
+		default:
+			log.Printf("Error getting LDAP connection: %v", err)
+		}
+		e.failures.WithLabelValues(stage).Inc()
+		return false, err
 	}
 
-	data, err := searchLDAP(conn, ldapTimeout)
+	entries, err := searchMonitor(ctx, conn, e.mod.Timeout)
 	if err != nil {
 		log.Printf("Error collecting LDAP stats: %v", err)
-		e.closeLDAPConn()
-		return
+		e.releaseLDAPConn(conn, err)
+		e.failures.WithLabelValues(stageSearch).Inc()
+		return false, err
 	}
 
-	v := reflect.ValueOf(data)
-	for i, m := range metricDefs {
-		vt := prometheus.GaugeValue
-		if m.kind == counterKind {
-			vt = prometheus.CounterValue
+	var firstErr, connErr error
+	for _, c := range e.collectors {
+		if err := c.collect(ctx, conn, e.mod.Timeout, entries, ch); err != nil {
+			log.Printf("Error collecting LDAP stats: %v", err)
+			e.failures.WithLabelValues(stageSearch).Inc()
+			if firstErr == nil {
+				firstErr = err
+			}
+			// A cancelled operation closed the connection.
+			if isCancelled(err) {
+				connErr = err
+				break
+			}
 		}
-		ch <- prometheus.MustNewConstMetric(e.descs[i], vt, v.Field(m.fieldIdx).Float())
 	}
+	e.releaseLDAPConn(conn, connErr)
+	return true, firstErr
 }
@@ -1,51 +1,174 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"reflect"
+	"runtime"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/ozgurcd/389DS-exporter/obj"
 )
 
 type mockLDAP struct {
-	searchFunc func(*ldap.SearchRequest) (*ldap.SearchResult, error)
-	closeFunc  func() error
+	searchFunc  func(*ldap.SearchRequest) (*ldap.SearchResult, error)
+	bindFunc    func(username, password string) error
+	extBindFunc func() error
+	compareFunc func(dn, attribute, value string) (bool, error)
+	closeFunc   func() error
 }
 
 func (m *mockLDAP) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
 	return m.searchFunc(req)
 }
 
+func (m *mockLDAP) Bind(username, password string) error {
+	if m.bindFunc == nil {
+		return nil
+	}
+	return m.bindFunc(username, password)
+}
+
+func (m *mockLDAP) ExternalBind() error {
+	if m.extBindFunc == nil {
+		return nil
+	}
+	return m.extBindFunc()
+}
+
+func (m *mockLDAP) Compare(dn, attribute, value string) (bool, error) {
+	return m.compareFunc(dn, attribute, value)
+}
+
 func (m *mockLDAP) Close() error {
 	return m.closeFunc()
 }
 
-func saveGlobals() func() {
-	origServer := server
-	origPort := port
-	origTimeout := ldapTimeout
-	return func() {
-		server = origServer
-		port = origPort
-		ldapTimeout = origTimeout
+// testModule returns connection settings suitable for mock dialers.
+func testModule() *module {
+	return &module{Timeout: 5 * time.Second}
+}
+
+// monitorTestEntries builds the cn=monitor subtree with every declared
+// attribute, the i-th of them set to value(i).
+func monitorTestEntries(value func(i int) string) []*ldap.Entry {
+	var entries []*ldap.Entry
+	i := 0
+	for _, def := range monitorEntryDefs {
+		e := &ldap.Entry{DN: def.dn}
+		for _, m := range def.metrics {
+			e.Attributes = append(e.Attributes, &ldap.EntryAttribute{Name: m.ldapName, Values: []string{value(i)}})
+			i++
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// monitorMetricCount is the number of metrics declared in monitorEntryDefs.
+func monitorMetricCount() int {
+	n := 0
+	for _, def := range monitorEntryDefs {
+		n += len(def.metrics)
 	}
+	return n
 }
 
-func attrsToLDAP(d obj.DSData) []*ldap.EntryAttribute {
-	v := reflect.ValueOf(d)
-	attrs := make([]*ldap.EntryAttribute, len(metricDefs))
-	for i, m := range metricDefs {
-		attrs[i] = &ldap.EntryAttribute{
-			Name:   m.ldapName,
-			Values: []string{fmtFloat(v.Field(m.fieldIdx).Float())},
+// routeMock answers each search with the entries registered for its base
+// DN, and with an empty result for any other base.
+func routeMock(routes map[string][]*ldap.Entry) *mockLDAP {
+	return &mockLDAP{
+		searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			return &ldap.SearchResult{Entries: routes[req.BaseDN]}, nil
+		},
+		closeFunc: func() error { return nil },
+	}
+}
+
+// mockExporter returns an exporter whose dialer always yields conn.
+func mockExporter(conn LDAPClient) *Exporter {
+	e := NewExporter("ldap://ldap.example.com:389", testModule())
+	e.dial = func(_ context.Context, addr string) (LDAPClient, error) { return conn, nil }
+	return e
+}
+
+// gatherValue collects c once through a fresh registry and returns the value
+// of the series called name whose labels include labels.
+func gatherValue(t *testing.T, c prometheus.Collector, name string, labels map[string]string) (float64, bool) {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range mf.GetMetric() {
+			got := make(map[string]string, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				got[lp.GetName()] = lp.GetValue()
+			}
+			for k, v := range labels {
+				if got[k] != v {
+					continue metrics
+				}
+			}
+			switch {
+			case m.GetGauge() != nil:
+				return m.GetGauge().GetValue(), true
+			case m.GetCounter() != nil:
+				return m.GetCounter().GetValue(), true
+			case m.GetUntyped() != nil:
+				return m.GetUntyped().GetValue(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// gatherHistogram is gatherValue for histograms: it returns the sample count
+// and sum of the matching series.
+func gatherHistogram(t *testing.T, c prometheus.Collector, name string, labels map[string]string) (uint64, float64, bool) {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range mf.GetMetric() {
+			got := make(map[string]string, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				got[lp.GetName()] = lp.GetValue()
+			}
+			for k, v := range labels {
+				if got[k] != v {
+					continue metrics
+				}
+			}
+			if h := m.GetHistogram(); h != nil {
+				return h.GetSampleCount(), h.GetSampleSum(), true
+			}
 		}
 	}
-	return attrs
+	return 0, 0, false
 }
 
 func fmtFloat(f float64) string {
@@ -53,14 +176,14 @@ func fmtFloat(f float64) string {
 }
 
 func TestNewExporter(t *testing.T) {
-	e := NewExporter()
+	e := NewExporter("ldap://ldap.example.com:389", testModule())
 	if e == nil {
 		t.Fatal("NewExporter() returned nil")
 	}
 }
 
 func TestNewExporterDescsNonNil(t *testing.T) {
-	e := NewExporter()
+	e := NewExporter("ldap://ldap.example.com:389", testModule())
 	v := reflect.ValueOf(e).Elem()
 	descType := reflect.TypeFor[*prometheus.Desc]()
 	for i := range v.NumField() {
@@ -75,8 +198,8 @@ func TestNewExporterDescsNonNil(t *testing.T) {
 }
 
 func TestDescribeSendsAllDescriptors(t *testing.T) {
-	e := NewExporter()
-	ch := make(chan *prometheus.Desc, 100)
+	e := NewExporter("ldap://ldap.example.com:389", testModule())
+	ch := make(chan *prometheus.Desc, 500)
 
 	e.Describe(ch)
 	close(ch)
@@ -86,25 +209,25 @@ func TestDescribeSendsAllDescriptors(t *testing.T) {
 		count++
 	}
 
-	// Expect 33 descriptors (all fields in Exporter struct)
-	if count != 33 {
-		t.Errorf("Describe sent %d descriptors, want 33", count)
+	// Expect the monitor descriptors plus up, scrape duration, last error
+	// and scrape failures, and those of the additional collectors: server,
+	// ldbm_info, agreement, RUV, connection, breaker, plugin and disk
+	// descriptors
+	want := monitorMetricCount() + 4 + len(backendMetricDefs) + len(ldbmMetricDefs) + 5 + 1 + 7 + 2 + 4 + 3 + 2 + 8
+	if count != want {
+		t.Errorf("Describe sent %d descriptors, want %d", count, want)
 	}
 }
 
 func TestGetLDAPConn_DialSuccess(t *testing.T) {
-	defer saveGlobals()()
-	server = "ldap.example.com"
-	port = 389
-	ldapTimeout = 5 * time.Second
-
 	e := &Exporter{
-		dial: func(addr string) (LDAPClient, error) {
+		mod: testModule(),
+		dial: func(_ context.Context, addr string) (LDAPClient, error) {
 			return &mockLDAP{closeFunc: func() error { return nil }}, nil
 		},
 	}
 
-	c, err := e.getLDAPConn()
+	c, err := e.getLDAPConn(context.Background())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -112,37 +235,103 @@ func TestGetLDAPConn_DialSuccess(t *testing.T) {
 		t.Fatal("expected non-nil client")
 	}
 
-	// Second call should return cached conn
-	c2, err := e.getLDAPConn()
+	// A released connection is reused by the next call
+	e.releaseLDAPConn(c, nil)
+	c2, err := e.getLDAPConn(context.Background())
 	if err != nil {
-		t.Fatalf("unexpected error on cached call: %v", err)
+		t.Fatalf("unexpected error on pooled call: %v", err)
 	}
 	if c != c2 {
-		t.Error("expected cached connection, got new one")
+		t.Error("expected pooled connection, got new one")
 	}
 }
 
 func TestGetLDAPConn_DialError(t *testing.T) {
-	defer saveGlobals()()
-	server = "ldap.example.com"
-	port = 389
-	ldapTimeout = 5 * time.Second
-
 	e := &Exporter{
-		dial: func(addr string) (LDAPClient, error) {
+		mod: testModule(),
+		dial: func(_ context.Context, addr string) (LDAPClient, error) {
 			return nil, errors.New("dial refused")
 		},
 	}
 
-	_, err := e.getLDAPConn()
+	_, err := e.getLDAPConn(context.Background())
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
 }
 
+func TestGetLDAPConn_BindsAfterDial(t *testing.T) {
+	mod := testModule()
+	mod.BindDN = "cn=Directory Manager"
+	mod.BindPassword = "secret"
+
+	var gotDN, gotPW string
+	dials := 0
+	e := &Exporter{
+		mod: mod,
+		dial: func(_ context.Context, addr string) (LDAPClient, error) {
+			dials++
+			return &mockLDAP{
+				bindFunc: func(dn, pw string) error {
+					gotDN, gotPW = dn, pw
+					return nil
+				},
+				closeFunc: func() error { return nil },
+			}, nil
+		},
+	}
+
+	c, err := e.getLDAPConn(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotDN != mod.BindDN || gotPW != mod.BindPassword {
+		t.Errorf("bound as %q/%q, want %q/%q", gotDN, gotPW, mod.BindDN, mod.BindPassword)
+	}
+
+	// A recreated connection must bind again
+	gotDN = ""
+	e.releaseLDAPConn(c, nil)
+	e.closeLDAPConn()
+	if _, err := e.getLDAPConn(context.Background()); err != nil {
+		t.Fatalf("unexpected error on redial: %v", err)
+	}
+	if dials != 2 || gotDN != mod.BindDN {
+		t.Errorf("dials = %d, bound as %q; want 2 dials and a re-bind", dials, gotDN)
+	}
+}
+
+func TestGetLDAPConn_BindError(t *testing.T) {
+	mod := testModule()
+	mod.BindDN = "cn=exporter"
+	mod.BindPassword = "wrong"
+
+	closed := false
+	e := &Exporter{
+		mod: mod,
+		dial: func(_ context.Context, addr string) (LDAPClient, error) {
+			return &mockLDAP{
+				bindFunc:  func(dn, pw string) error { return errors.New("invalid credentials") },
+				closeFunc: func() error { closed = true; return nil },
+			}, nil
+		},
+	}
+
+	_, err := e.getLDAPConn(context.Background())
+	if !errors.Is(err, errBind) {
+		t.Fatalf("err = %v, want errBind", err)
+	}
+	if !closed {
+		t.Error("expected connection to be closed after failed bind")
+	}
+	if _, _, idle, inUse := e.connections().stats(); idle != 0 || inUse != 0 {
+		t.Errorf("failed bind left %d idle and %d in-use connections, want none", idle, inUse)
+	}
+}
+
 func TestGetLDAPConn_NoDial(t *testing.T) {
-	e := &Exporter{}
-	_, err := e.getLDAPConn()
+	e := &Exporter{mod: testModule()}
+	_, err := e.getLDAPConn(context.Background())
 	if err == nil {
 		t.Fatal("expected error when no dial function configured")
 	}
@@ -162,12 +351,12 @@ func TestSearchLDAP_Success(t *testing.T) {
 		},
 	}
 
-	data, err := searchLDAP(mock, 5*time.Second)
+	data, err := searchLDAP(context.Background(), mock, 5*time.Second)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if data.Threads != 4 {
-		t.Errorf("Threads = %v, want 4", data.Threads)
+	if v, _ := data.Get("cn=monitor", "threads"); v != 4 {
+		t.Errorf("threads = %v, want 4", v)
 	}
 }
 
@@ -178,25 +367,30 @@ func TestSearchLDAP_Error(t *testing.T) {
 		},
 	}
 
-	_, err := searchLDAP(mock, 5*time.Second)
+	_, err := searchLDAP(context.Background(), mock, 5*time.Second)
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
 }
 
 func TestSearchLDAP_Timeout(t *testing.T) {
-	block := make(chan struct{})
+	// Like a real connection, the mock fails the pending search when it is
+	// closed.
+	closed := make(chan struct{})
 	mock := &mockLDAP{
 		searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
-			<-block
-			return nil, errors.New("should not reach here")
+			<-closed
+			return nil, errors.New("connection closed")
+		},
+		closeFunc: func() error {
+			close(closed)
+			return nil
 		},
 	}
-	defer close(block)
 
-	_, err := searchLDAP(mock, 0)
-	if err == nil {
-		t.Fatal("expected timeout error, got nil")
+	_, err := searchLDAP(context.Background(), mock, 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want a deadline error", err)
 	}
 }
 
@@ -212,39 +406,39 @@ func TestCloseLDAPConn(t *testing.T) {
 		},
 	}
 
-	e := &Exporter{ldapConn: mock}
+	e := &Exporter{mod: testModule(), dial: func(context.Context, string) (LDAPClient, error) { return mock, nil }}
+	c, err := e.getLDAPConn(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	e.releaseLDAPConn(c, nil)
 	e.closeLDAPConn()
 
 	if !closed {
 		t.Error("expected Close() to be called")
 	}
-	if e.ldapConn != nil {
-		t.Error("expected ldapConn to be nil after close")
+	if _, _, idle, _ := e.connections().stats(); idle != 0 {
+		t.Errorf("%d idle connections after close, want 0", idle)
 	}
 }
 
 func TestCollect_Success(t *testing.T) {
-	defer saveGlobals()()
-	server = "ldap.example.com"
-	port = 389
-	ldapTimeout = 5 * time.Second
-
 	mock := &mockLDAP{
 		searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			if req.BaseDN != "cn=monitor" {
+				return &ldap.SearchResult{}, nil
+			}
 			return &ldap.SearchResult{
-				Entries: []*ldap.Entry{{
-					DN:         "cn=monitor",
-					Attributes: attrsToLDAP(obj.DSData{}),
-				}},
+				Entries: monitorTestEntries(func(int) string { return "0" }),
 			}, nil
 		},
 		closeFunc: func() error { return nil },
 	}
 
-	e := NewExporter()
-	e.dial = func(addr string) (LDAPClient, error) { return mock, nil }
+	e := NewExporter("ldap://ldap.example.com:389", testModule())
+	e.dial = func(_ context.Context, addr string) (LDAPClient, error) { return mock, nil }
 
-	ch := make(chan prometheus.Metric, 40)
+	ch := make(chan prometheus.Metric, 100)
 	e.Collect(ch)
 	close(ch)
 
@@ -252,17 +446,20 @@ func TestCollect_Success(t *testing.T) {
 	for range ch {
 		count++
 	}
-	if count != 33 {
-		t.Errorf("expected 33 metrics, got %d", count)
+	// The monitor metrics, up, duration, last error, 4 failure stages and 6
+	// breaker and pool metrics
+	if want := monitorMetricCount() + 13; count != want {
+		t.Errorf("expected %d metrics, got %d", want, count)
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_up", nil); v != 1 {
+		t.Errorf("ds_exporter_up = %v, want 1", v)
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_last_scrape_error", nil); v != 0 {
+		t.Errorf("ds_exporter_last_scrape_error = %v, want 0", v)
 	}
 }
 
 func TestCollect_SearchError(t *testing.T) {
-	defer saveGlobals()()
-	server = "ldap.example.com"
-	port = 389
-	ldapTimeout = 5 * time.Second
-
 	mock := &mockLDAP{
 		searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
 			return nil, errors.New("search exploded")
@@ -270,38 +467,205 @@ func TestCollect_SearchError(t *testing.T) {
 		closeFunc: func() error { return nil },
 	}
 
-	e := NewExporter()
-	e.dial = func(addr string) (LDAPClient, error) { return mock, nil }
+	e := NewExporter("ldap://ldap.example.com:389", testModule())
+	e.dial = func(_ context.Context, addr string) (LDAPClient, error) { return mock, nil }
 
-	ch := make(chan prometheus.Metric, 40)
-	e.Collect(ch)
-	close(ch)
-
-	for range ch {
-		t.Error("expected no metrics on search error")
+	// Every gatherValue call is a fresh scrape
+	if v, _ := gatherValue(t, e, "ds_exporter_scrape_failures_total", map[string]string{"stage": stageSearch}); v != 1 {
+		t.Errorf("search failures = %v, want 1", v)
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_scrape_failures_total", map[string]string{"stage": stageDial}); v != 0 {
+		t.Errorf("dial failures = %v, want 0", v)
+	}
+	if _, ok := gatherValue(t, e, "ds_exporter_threads", nil); ok {
+		t.Error("expected no monitor metrics on search error")
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_up", nil); v != 0 {
+		t.Errorf("ds_exporter_up = %v, want 0", v)
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_last_scrape_error", nil); v != 1 {
+		t.Errorf("ds_exporter_last_scrape_error = %v, want 1", v)
 	}
 }
 
-func TestCollectHandlesConnectionError(t *testing.T) {
-	defer saveGlobals()()
+func TestCollect_BindErrorStage(t *testing.T) {
+	mod := testModule()
+	mod.BindDN = "cn=exporter"
+	mod.BindPassword = "wrong"
+
+	e := NewExporter("ldap://ldap.example.com:389", mod)
+	e.dial = func(_ context.Context, addr string) (LDAPClient, error) {
+		return &mockLDAP{
+			bindFunc:  func(string, string) error { return errors.New("invalid credentials") },
+			closeFunc: func() error { return nil },
+		}, nil
+	}
 
-	server = "192.0.2.1" // TEST-NET address, guaranteed unroutable
-	port = 1
-	ldapTimeout = 0
+	if v, _ := gatherValue(t, e, "ds_exporter_scrape_failures_total", map[string]string{"stage": stageBind}); v != 1 {
+		t.Errorf("bind failures = %v, want 1", v)
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_up", nil); v != 0 {
+		t.Errorf("ds_exporter_up = %v, want 0", v)
+	}
+}
+
+func TestConnStage(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errors.New("connection refused"), stageDial},
+		{fmt.Errorf("%w: bad certificate", errTLS), stageTLS},
+		{fmt.Errorf("%w as cn=x: invalid credentials", errBind), stageBind},
+	}
+	for _, tt := range tests {
+		if got := connStage(tt.err); got != tt.want {
+			t.Errorf("connStage(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
 
-	e := NewExporter()
+func TestCollectHandlesConnectionError(t *testing.T) {
+	// TEST-NET address, guaranteed unroutable
+	e := NewExporter("ldap://192.0.2.1:1", &module{Timeout: 0})
 	ch := make(chan prometheus.Metric, 100)
 
-	// Should not panic, should not send any metrics
+	// Should not panic, should only send the scrape health metrics
 	e.Collect(ch)
 	close(ch)
 
-	// No metrics should be produced when LDAP is unreachable
+	// Only up, duration, last error, 4 failure stages, 3 breaker states,
+	// breaker openings and 2 pool states when LDAP is unreachable
 	count := 0
 	for range ch {
 		count++
 	}
-	if count != 0 {
-		t.Errorf("Collect produced %d metrics on connection failure, want 0", count)
+	if count != 13 {
+		t.Errorf("Collect produced %d metrics on connection failure, want 13", count)
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_up", nil); v != 0 {
+		t.Errorf("ds_exporter_up = %v, want 0", v)
+	}
+}
+
+// waitGoroutines waits for the number of goroutines to drop to at most n and
+// returns the last count.
+func waitGoroutines(n int) int {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := runtime.NumGoroutine()
+		if got <= n || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCollect_HungServerNoGoroutineLeak(t *testing.T) {
+	addr := newSilentLDAPStub(t)
+	for _, bindDN := range []string{"", "cn=exporter"} {
+		t.Run("bind="+bindDN, func(t *testing.T) {
+			mod := &module{Timeout: 50 * time.Millisecond, BindDN: bindDN, BindPassword: "secret"}
+			e := NewExporter("ldap://"+addr, mod)
+			base := runtime.NumGoroutine()
+
+			for range 20 {
+				if v, _ := gatherValue(t, e, "ds_exporter_up", nil); v != 0 {
+					t.Fatalf("ds_exporter_up = %v against a hung server, want 0", v)
+				}
+			}
+			e.closeLDAPConn()
+
+			if got := waitGoroutines(base); got > base {
+				t.Errorf("goroutines = %d after 20 timed out scrapes, want at most %d", got, base)
+			}
+		})
+	}
+}
+
+func TestCollectContext_Cancelled(t *testing.T) {
+	addr := newSilentLDAPStub(t)
+	// The module timeout outlasts the test: only the scrape context can end
+	// the search.
+	e := NewExporter("ldap://"+addr, &module{Timeout: time.Minute})
+	base := runtime.NumGoroutine()
+
+	for range 5 {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		if ok, _ := e.collect(ctx, make(chan prometheus.Metric, 100)); ok {
+			t.Error("collect succeeded against a hung server")
+		}
+		cancel()
+		if d := time.Since(start); d > 5*time.Second {
+			t.Fatalf("collect took %v, the scrape context was not honoured", d)
+		}
+	}
+	e.closeLDAPConn()
+
+	if got := waitGoroutines(base); got > base {
+		t.Errorf("goroutines = %d after 5 cancelled scrapes, want at most %d", got, base)
+	}
+}
+
+func TestRunLDAP_ClosesOnCancel(t *testing.T) {
+	addr := newSilentLDAPStub(t)
+	base := runtime.NumGoroutine()
+
+	conn, err := dialLDAP(context.Background(), "ldap://"+addr, &module{Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = searchMonitor(ctx, conn, time.Minute)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+
+	// The connection is not closed here: runLDAP must have done it.
+	if got := waitGoroutines(base); got > base {
+		t.Errorf("goroutines = %d after a cancelled search, want at most %d", got, base)
+	}
+}
+
+func describeAll(describe func(chan<- *prometheus.Desc)) map[string]bool {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		describe(ch)
+		close(ch)
+	}()
+	descs := make(map[string]bool)
+	for d := range ch {
+		descs[d.String()] = true
+	}
+	return descs
+}
+
+// TestDescribeBuiltin_CoversExporter guards the reference list the
+// configuration is checked against: every metric of a fully configured
+// target must be in it.
+func TestDescribeBuiltin_CoversExporter(t *testing.T) {
+	orig := syntheticProbes
+	syntheticProbes = []*syntheticProbe{{name: "login"}}
+	t.Cleanup(func() { syntheticProbes = orig })
+
+	mod := &module{Timeout: time.Second, TLS: tlsSettings{Check: []string{tlsModeLDAPS}}}
+	if err := mod.validate(); err != nil {
+		t.Fatal(err)
+	}
+	e := NewExporter("ldap://ldap.example.com:389", mod)
+	builtin := describeAll(describeBuiltin)
+	for _, c := range []prometheus.Collector{
+		newPoller(e, time.Second),
+		newAccessLogCollector(),
+		newErrorsLogCollector(nil),
+		newReplicationLagCollector(nil, 0),
+	} {
+		for d := range describeAll(c.Describe) {
+			if !builtin[d] {
+				t.Errorf("describeBuiltin misses %s", d)
+			}
+		}
 	}
 }
@@ -3,31 +3,27 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/ozgurcd/389DS-exporter/obj"
 )
 
-// Helper function to parse float with error handling
-func parseFloatWithDefault(value, fieldName string) float64 {
-	if value == "" {
-		return 0
-	}
-
-	result, err := strconv.ParseFloat(value, 64)
+// searchLDAP reads the numeric attributes of the cn=monitor subtree.
+func searchLDAP(ctx context.Context, conn LDAPClient, timeout time.Duration) (obj.DSData, error) {
+	entries, err := searchMonitor(ctx, conn, timeout)
 	if err != nil {
-		log.Printf("[ERROR] %s: %v", fieldName, err)
-		return 0
+		return nil, err
 	}
-	return result
+	return parseMonitorAttrs(entries), nil
 }
 
-func searchLDAP(conn LDAPClient, timeout time.Duration) (obj.DSData, error) {
+// searchMonitor returns every entry of the cn=monitor subtree.
+func searchMonitor(ctx context.Context, conn LDAPClient, timeout time.Duration) ([]*ldap.Entry, error) {
 	searchRequest := ldap.NewSearchRequest(
 		"cn=monitor",
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
@@ -36,30 +32,106 @@ func searchLDAP(conn LDAPClient, timeout time.Duration) (obj.DSData, error) {
 		nil,
 	)
 
-	return runWithTimeout(timeout, func() (obj.DSData, error) {
+	return runLDAP(ctx, conn, timeout, func() ([]*ldap.Entry, error) {
 		sr, err := conn.Search(searchRequest)
 		if err != nil {
-			return obj.DSData{}, fmt.Errorf("LDAP search failed: %w", err)
+			return nil, fmt.Errorf("LDAP search failed: %w", err)
 		}
 		if sr == nil {
-			return obj.DSData{}, fmt.Errorf("LDAP search returned nil result")
+			return nil, fmt.Errorf("LDAP search returned nil result")
 		}
-		return parseMonitorAttrs(sr.Entries), nil
+		return sr.Entries, nil
 	})
 }
 
+// parseMonitorAttrs keys the numeric attributes of entries by entry DN.
+// Entries that share a DN are merged.
 func parseMonitorAttrs(entries []*ldap.Entry) obj.DSData {
-	var d obj.DSData
-	v := reflect.ValueOf(&d).Elem()
+	d := make(obj.DSData, len(entries))
 	for _, entry := range entries {
-		for _, attr := range entry.Attributes {
-			if len(attr.Values) == 0 {
-				continue
-			}
-			if idx, ok := ldapFieldMap[attr.Name]; ok {
-				v.Field(idx).SetFloat(parseFloatWithDefault(attr.Values[0], attr.Name))
-			}
+		dn := strings.ToLower(entry.DN)
+		if d[dn] == nil {
+			d[dn] = entryFloats(entry)
+			continue
+		}
+		for k, v := range entryFloats(entry) {
+			d[dn][k] = v
 		}
 	}
 	return d
 }
+
+// searchBase reads a single entry with a base-scope search.
+func searchBase(ctx context.Context, conn LDAPClient, dn string, attrs []string, timeout time.Duration) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectclass=*)",
+		attrs,
+		nil,
+	)
+	return runLDAP(ctx, conn, timeout, func() (*ldap.Entry, error) {
+		sr, err := conn.Search(req)
+		if err != nil {
+			return nil, fmt.Errorf("LDAP search of %s failed: %w", dn, err)
+		}
+		if sr == nil || len(sr.Entries) == 0 {
+			return nil, fmt.Errorf("LDAP search of %s returned no entry", dn)
+		}
+		return sr.Entries[0], nil
+	})
+}
+
+// entryFloats parses the first value of every numeric attribute of entry,
+// keyed by lower-cased attribute name. Non-numeric attributes are skipped.
+func entryFloats(entry *ldap.Entry) obj.Samples {
+	values := make(obj.Samples, len(entry.Attributes))
+	for _, attr := range entry.Attributes {
+		if len(attr.Values) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(attr.Values[0], 64)
+		if err != nil {
+			continue
+		}
+		values[strings.ToLower(attr.Name)] = v
+	}
+	return values
+}
+
+// searchSubtree returns base and every entry below it.
+func searchSubtree(ctx context.Context, conn LDAPClient, base string, attrs []string, timeout time.Duration) ([]*ldap.Entry, error) {
+	return searchFilter(ctx, conn, base, "(objectclass=*)", attrs, timeout)
+}
+
+// searchFilter returns the entries of the subtree under base matching filter.
+func searchFilter(ctx context.Context, conn LDAPClient, base, filter string, attrs []string, timeout time.Duration) ([]*ldap.Entry, error) {
+	return searchScope(ctx, conn, base, ldap.ScopeWholeSubtree, filter, attrs, timeout)
+}
+
+// searchOneLevel returns the immediate children of base matching filter,
+// such as the plugin entries under cn=plugins,cn=config, without descending
+// into the rest of the config tree.
+func searchOneLevel(ctx context.Context, conn LDAPClient, base, filter string, attrs []string, timeout time.Duration) ([]*ldap.Entry, error) {
+	return searchScope(ctx, conn, base, ldap.ScopeSingleLevel, filter, attrs, timeout)
+}
+
+func searchScope(ctx context.Context, conn LDAPClient, base string, scope int, filter string, attrs []string, timeout time.Duration) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		base,
+		scope, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attrs,
+		nil,
+	)
+	return runLDAP(ctx, conn, timeout, func() ([]*ldap.Entry, error) {
+		sr, err := conn.Search(req)
+		if err != nil {
+			return nil, fmt.Errorf("LDAP search of %s failed: %w", base, err)
+		}
+		if sr == nil {
+			return nil, fmt.Errorf("LDAP search of %s returned nil result", base)
+		}
+		return sr.Entries, nil
+	})
+}
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"reflect"
-	"strconv"
 	"testing"
 
 	"github.com/go-ldap/ldap/v3"
@@ -10,8 +8,8 @@ import (
 
 func TestParseMonitorAttrs_NoEntries(t *testing.T) {
 	d := parseMonitorAttrs(nil)
-	if d.Threads != 0 {
-		t.Error("expected zero DSData with nil entries")
+	if _, ok := d.Get("cn=monitor", "threads"); ok {
+		t.Error("expected no samples with nil entries")
 	}
 }
 
@@ -24,90 +22,68 @@ func TestParseMonitorAttrs_Partial(t *testing.T) {
 		},
 	}}
 	d := parseMonitorAttrs(entries)
-	if d.Threads != 4 {
-		t.Errorf("Threads = %v, want 4", d.Threads)
+	if v, _ := d.Get("cn=monitor", "threads"); v != 4 {
+		t.Errorf("threads = %v, want 4", v)
 	}
-	if d.Readwaiters != 2 {
-		t.Errorf("Readwaiters = %v, want 2", d.Readwaiters)
+	if v, _ := d.Get("cn=monitor", "readwaiters"); v != 2 {
+		t.Errorf("readwaiters = %v, want 2", v)
 	}
-	if d.Cachehits != 0 {
-		t.Errorf("Cachehits = %v, want 0 (unset)", d.Cachehits)
+	if _, ok := d.Get("cn=monitor", "cachehits"); ok {
+		t.Error("cachehits should be absent (unset)")
 	}
 }
 
-func TestParseMonitorAttrs_AllFields(t *testing.T) {
-	attrs := make([]*ldap.EntryAttribute, len(metricDefs))
-	for i, m := range metricDefs {
-		attrs[i] = &ldap.EntryAttribute{Name: m.ldapName, Values: []string{strconv.Itoa(i + 1)}}
-	}
-
-	d := parseMonitorAttrs([]*ldap.Entry{{DN: "cn=monitor", Attributes: attrs}})
+func TestParseMonitorAttrs_AllDeclared(t *testing.T) {
+	entries := monitorTestEntries(func(i int) string { return fmtFloat(float64(i + 1)) })
+	d := parseMonitorAttrs(entries)
 
-	v := reflect.ValueOf(d)
-	for i, m := range metricDefs {
-		got := v.Field(m.fieldIdx).Float()
-		want := float64(i + 1)
-		if got != want {
-			t.Errorf("[%d] %s = %v, want %v", m.fieldIdx, m.ldapName, got, want)
+	i := 0
+	for _, def := range monitorEntryDefs {
+		for _, m := range def.metrics {
+			want := float64(i + 1)
+			if got, ok := d.Get(def.dn, m.ldapName); !ok || got != want {
+				t.Errorf("%s %s = %v (present %v), want %v", def.dn, m.ldapName, got, ok, want)
+			}
+			i++
 		}
 	}
 }
 
-func TestParseMonitorAttrs_InvalidValuesDefaultToZero(t *testing.T) {
+func TestParseMonitorAttrs_InvalidValuesSkipped(t *testing.T) {
 	entries := []*ldap.Entry{{
 		DN: "cn=monitor",
 		Attributes: []*ldap.EntryAttribute{
 			{Name: "threads", Values: []string{"not-a-number"}},
 			{Name: "readwaiters", Values: []string{""}},
+			{Name: "version", Values: []string{"389-Directory/2.4.5"}},
 		},
 	}}
 	d := parseMonitorAttrs(entries)
-	if d.Threads != 0 {
-		t.Errorf("Threads = %v, want 0 (invalid parse)", d.Threads)
-	}
-	if d.Readwaiters != 0 {
-		t.Errorf("Readwaiters = %v, want 0 (empty string)", d.Readwaiters)
+	for _, attr := range []string{"threads", "readwaiters", "version"} {
+		if _, ok := d.Get("cn=monitor", attr); ok {
+			t.Errorf("%s should be skipped", attr)
+		}
 	}
 }
 
-func TestParseMonitorAttrs_MultipleEntries(t *testing.T) {
+func TestParseMonitorAttrs_KeepsEntryProvenance(t *testing.T) {
 	entries := []*ldap.Entry{
 		{DN: "cn=monitor", Attributes: []*ldap.EntryAttribute{
 			{Name: "threads", Values: []string{"8"}},
+			{Name: "bytessent", Values: []string{"100"}},
 		}},
-		{DN: "cn=other", Attributes: []*ldap.EntryAttribute{
-			{Name: "readwaiters", Values: []string{"3"}},
+		{DN: "cn=snmp,cn=monitor", Attributes: []*ldap.EntryAttribute{
+			{Name: "bytesSent", Values: []string{"200"}},
 		}},
 	}
 	d := parseMonitorAttrs(entries)
-	if d.Threads != 8 {
-		t.Errorf("Threads = %v, want 8", d.Threads)
+	if v, _ := d.Get("cn=monitor", "bytessent"); v != 100 {
+		t.Errorf("cn=monitor bytessent = %v, want 100", v)
 	}
-	if d.Readwaiters != 3 {
-		t.Errorf("Readwaiters = %v, want 3", d.Readwaiters)
+	if v, _ := d.Get("cn=snmp,cn=monitor", "bytessent"); v != 200 {
+		t.Errorf("cn=snmp bytessent = %v, want 200", v)
 	}
-}
-
-func TestParseFloatWithDefault(t *testing.T) {
-	tests := []struct {
-		name  string
-		value string
-		want  float64
-	}{
-		{"valid integer", "42", 42},
-		{"valid float", "3.14", 3.14},
-		{"valid negative", "-5", -5},
-		{"empty string", "", 0},
-		{"invalid string", "not-a-number", 0},
-		{"scientific notation", "1e5", 100000},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := parseFloatWithDefault(tt.value, tt.name)
-			if got != tt.want {
-				t.Errorf("parseFloatWithDefault(%q) = %v, want %v", tt.value, got, tt.want)
-			}
-		})
+	if _, ok := d.Get("cn=snmp,cn=monitor", "threads"); ok {
+		t.Error("threads leaked into cn=snmp")
 	}
 }
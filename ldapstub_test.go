@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapStub is a minimal LDAP server for tests. It answers binds according to
+// bindResult and returns entries for every search.
+type ldapStub struct {
+	ln      net.Listener
+	entries []*ldap.Entry
+
+	mu    sync.Mutex
+	binds []string // "simple:<dn>" or "sasl:<mechanism>"
+
+	// bindResult is the LDAP result code returned for binds.
+	bindResult int64
+	// tlsCert, when set, is presented to clients that send StartTLS.
+	tlsCert *tls.Certificate
+}
+
+// newUnixLDAPStub starts a stub listening on a Unix socket in a temp dir and
+// returns it with the socket path.
+func newUnixLDAPStub(t *testing.T, entries []*ldap.Entry) (*ldapStub, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "slapd.socket")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ldapStub{ln: ln, entries: entries}
+	t.Cleanup(func() { _ = ln.Close() })
+	go s.serve()
+	return s, path
+}
+
+// newStartTLSLDAPStub starts a stub on a TCP port that upgrades connections
+// to TLS with cert on StartTLS, and returns its address.
+func newStartTLSLDAPStub(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ldapStub{ln: ln, tlsCert: &cert}
+	t.Cleanup(func() { _ = ln.Close() })
+	go s.serve()
+	return ln.Addr().String()
+}
+
+func (s *ldapStub) bindLog() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...)
+}
+
+func (s *ldapStub) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *ldapStub) handle(c net.Conn) {
+	defer func() { _ = c.Close() }()
+	for {
+		p, err := ber.ReadPacket(c)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id := p.Children[0].Value.(int64)
+		op := p.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			s.recordBind(op)
+			s.mu.Lock()
+			code := s.bindResult
+			s.mu.Unlock()
+			_, _ = c.Write(stubResult(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			for _, e := range s.entries {
+				_, _ = c.Write(stubEntry(id, e).Bytes())
+			}
+			_, _ = c.Write(stubResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		case ldap.ApplicationExtendedRequest:
+			if s.tlsCert == nil {
+				_, _ = c.Write(stubResult(id, ldap.ApplicationExtendedResponse, ldap.LDAPResultUnavailable).Bytes())
+				continue
+			}
+			_, _ = c.Write(stubResult(id, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess).Bytes())
+			c = tls.Server(c, &tls.Config{Certificates: []tls.Certificate{*s.tlsCert}, MinVersion: tls.VersionTLS12})
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *ldapStub) recordBind(op *ber.Packet) {
+	if len(op.Children) < 3 {
+		return
+	}
+	var kind string
+	auth := op.Children[2]
+	switch auth.Tag {
+	case 0:
+		kind = "simple:" + op.Children[1].Data.String()
+	case 3:
+		kind = "sasl:" + auth.Children[0].Data.String()
+	}
+	s.mu.Lock()
+	s.binds = append(s.binds, kind)
+	s.mu.Unlock()
+}
+
+func stubEnvelope(id int64) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	return p
+}
+
+func stubResult(id int64, tag ber.Tag, code int64) *ber.Packet {
+	p := stubEnvelope(id)
+	r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	r.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	p.AppendChild(r)
+	return p
+}
+
+func stubEntry(id int64, e *ldap.Entry) *ber.Packet {
+	p := stubEnvelope(id)
+	r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "objectName"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for _, a := range e.Attributes {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a.Name, "type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, v := range a.Values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		}
+		attr.AppendChild(vals)
+		attrs.AppendChild(attr)
+	}
+	r.AppendChild(attrs)
+	p.AppendChild(r)
+	return p
+}
+
+// newSilentLDAPStub starts a TCP server that accepts connections and reads
+// every request without ever answering, like a hung directory server. It
+// returns the listen address.
+func newSilentLDAPStub(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = c.Close() }()
+				_, _ = io.Copy(io.Discard, c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
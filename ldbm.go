@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/ozgurcd/389DS-exporter/obj"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ldbmMonitorDN holds the database environment statistics. On Berkeley DB
+// servers the nsslapd-db-* lock and transaction counters live in its
+// cn=database child, which is why the collector searches the subtree.
+const ldbmMonitorDN = "cn=monitor,cn=ldbm database,cn=plugins,cn=config"
+
+// Database implementations reported by ds_exporter_ldbm_info.
+const (
+	ldbmImplBDB = "bdb"
+	ldbmImplMDB = "mdb"
+)
+
+// ldbmMetricDefs covers the attribute sets of both the Berkeley DB and the
+// LMDB (389-DS 3.x) backends. Only attributes present on the server are
+// exported.
+var ldbmMetricDefs = []attrMetricDef{
+	// Database cache, both implementations
+	{ldapName: "dbcachehits", help: "Number of database cache lookups that found the page", kind: counterKind, label: "ldbm_dbcachehits"},
+	{ldapName: "dbcachetries", help: "Number of database cache lookups", kind: counterKind, label: "ldbm_dbcachetries"},
+	{ldapName: "dbcachehitratio", help: "Percentage of database cache lookups that found the page", kind: gaugeKind, label: "ldbm_dbcachehitratio"},
+	{ldapName: "dbcachepagein", help: "Number of pages read into the database cache", kind: counterKind, label: "ldbm_dbcachepagein"},
+	{ldapName: "dbcachepageout", help: "Number of pages written from the database cache", kind: counterKind, label: "ldbm_dbcachepageout"},
+	{ldapName: "dbcacheroevict", help: "Number of clean pages evicted from the database cache", kind: counterKind, label: "ldbm_dbcacheroevict"},
+	{ldapName: "dbcacherwevict", help: "Number of dirty pages evicted from the database cache", kind: counterKind, label: "ldbm_dbcacherwevict"},
+
+	// Normalized DN cache
+	{ldapName: "normalizeddncachetries", help: "Number of normalized DN cache lookups", kind: counterKind, label: "ldbm_normalizeddncachetries"},
+	{ldapName: "normalizeddncachehits", help: "Number of normalized DN cache lookups that found the DN", kind: counterKind, label: "ldbm_normalizeddncachehits"},
+	{ldapName: "normalizeddncachemisses", help: "Number of normalized DN cache lookups that missed", kind: counterKind, label: "ldbm_normalizeddncachemisses"},
+	{ldapName: "normalizeddncachehitratio", help: "Percentage of normalized DN cache lookups that found the DN", kind: gaugeKind, label: "ldbm_normalizeddncachehitratio"},
+	{ldapName: "normalizeddncacheevictions", help: "Number of DNs evicted from the normalized DN cache", kind: counterKind, label: "ldbm_normalizeddncacheevictions"},
+	{ldapName: "currentnormalizeddncachesize", help: "Current size of the normalized DN cache in bytes", kind: gaugeKind, label: "ldbm_currentnormalizeddncachesize"},
+	{ldapName: "maxnormalizeddncachesize", help: "Configured maximum size of the normalized DN cache in bytes", kind: gaugeKind, label: "ldbm_maxnormalizeddncachesize"},
+	{ldapName: "currentnormalizeddncachecount", help: "Number of DNs in the normalized DN cache", kind: gaugeKind, label: "ldbm_currentnormalizeddncachecount"},
+
+	// Berkeley DB environment (cn=database,cn=monitor,cn=ldbm database)
+	{ldapName: "nsslapd-db-cache-hit", help: "Number of Berkeley DB cache hits", kind: counterKind, label: "ldbm_db_cache_hit"},
+	{ldapName: "nsslapd-db-cache-try", help: "Number of Berkeley DB cache lookups", kind: counterKind, label: "ldbm_db_cache_try"},
+	{ldapName: "nsslapd-db-cache-size-bytes", help: "Size of the Berkeley DB cache in bytes", kind: gaugeKind, label: "ldbm_db_cache_size_bytes"},
+	{ldapName: "nsslapd-db-cache-region-wait-rate", help: "Number of times a thread waited for the cache region lock", kind: counterKind, label: "ldbm_db_cache_region_wait_rate"},
+	{ldapName: "nsslapd-db-page-read-rate", help: "Number of pages read into the Berkeley DB cache", kind: counterKind, label: "ldbm_db_page_read_rate"},
+	{ldapName: "nsslapd-db-page-write-rate", help: "Number of pages written from the Berkeley DB cache", kind: counterKind, label: "ldbm_db_page_write_rate"},
+	{ldapName: "nsslapd-db-page-create-rate", help: "Number of pages created in the Berkeley DB cache", kind: counterKind, label: "ldbm_db_page_create_rate"},
+	{ldapName: "nsslapd-db-page-ro-evict-rate", help: "Number of clean pages evicted from the Berkeley DB cache", kind: counterKind, label: "ldbm_db_page_ro_evict_rate"},
+	{ldapName: "nsslapd-db-page-rw-evict-rate", help: "Number of dirty pages evicted from the Berkeley DB cache", kind: counterKind, label: "ldbm_db_page_rw_evict_rate"},
+	{ldapName: "nsslapd-db-page-trickle-rate", help: "Number of dirty pages written by the trickle thread", kind: counterKind, label: "ldbm_db_page_trickle_rate"},
+	{ldapName: "nsslapd-db-pages-in-use", help: "Number of pages in the Berkeley DB cache", kind: gaugeKind, label: "ldbm_db_pages_in_use"},
+	{ldapName: "nsslapd-db-clean-pages", help: "Number of clean pages in the Berkeley DB cache", kind: gaugeKind, label: "ldbm_db_clean_pages"},
+	{ldapName: "nsslapd-db-dirty-pages", help: "Number of dirty pages in the Berkeley DB cache", kind: gaugeKind, label: "ldbm_db_dirty_pages"},
+	{ldapName: "nsslapd-db-hash-buckets", help: "Number of hash buckets in the Berkeley DB cache", kind: gaugeKind, label: "ldbm_db_hash_buckets"},
+	{ldapName: "nsslapd-db-hash-elements-examine-rate", help: "Number of hash elements examined during cache lookups", kind: counterKind, label: "ldbm_db_hash_elements_examine_rate"},
+	{ldapName: "nsslapd-db-hash-search-rate", help: "Number of Berkeley DB cache hash searches", kind: counterKind, label: "ldbm_db_hash_search_rate"},
+	{ldapName: "nsslapd-db-longest-chain-length", help: "Longest hash chain searched in the Berkeley DB cache", kind: gaugeKind, label: "ldbm_db_longest_chain_length"},
+	{ldapName: "nsslapd-db-lock-conflicts", help: "Number of Berkeley DB lock requests that could not be granted immediately", kind: counterKind, label: "ldbm_db_lock_conflicts"},
+	{ldapName: "nsslapd-db-lock-request-rate", help: "Number of Berkeley DB lock requests", kind: counterKind, label: "ldbm_db_lock_request_rate"},
+	{ldapName: "nsslapd-db-lock-region-wait-rate", help: "Number of times a thread waited for the lock region lock", kind: counterKind, label: "ldbm_db_lock_region_wait_rate"},
+	{ldapName: "nsslapd-db-deadlock-rate", help: "Number of Berkeley DB deadlocks detected", kind: counterKind, label: "ldbm_db_deadlock_rate"},
+	{ldapName: "nsslapd-db-configured-locks", help: "Configured number of Berkeley DB locks", kind: gaugeKind, label: "ldbm_db_configured_locks"},
+	{ldapName: "nsslapd-db-current-locks", help: "Number of Berkeley DB locks currently held", kind: gaugeKind, label: "ldbm_db_current_locks"},
+	{ldapName: "nsslapd-db-max-locks", help: "Maximum number of Berkeley DB locks held at once", kind: gaugeKind, label: "ldbm_db_max_locks"},
+	{ldapName: "nsslapd-db-current-lock-objects", help: "Number of Berkeley DB lock objects currently in use", kind: gaugeKind, label: "ldbm_db_current_lock_objects"},
+	{ldapName: "nsslapd-db-max-lock-objects", help: "Maximum number of Berkeley DB lock objects in use at once", kind: gaugeKind, label: "ldbm_db_max_lock_objects"},
+	{ldapName: "nsslapd-db-lockers", help: "Number of Berkeley DB lockers", kind: gaugeKind, label: "ldbm_db_lockers"},
+	{ldapName: "nsslapd-db-active-txns", help: "Number of active Berkeley DB transactions", kind: gaugeKind, label: "ldbm_db_active_txns"},
+	{ldapName: "nsslapd-db-commit-rate", help: "Number of committed Berkeley DB transactions", kind: counterKind, label: "ldbm_db_commit_rate"},
+	{ldapName: "nsslapd-db-abort-rate", help: "Number of aborted Berkeley DB transactions", kind: counterKind, label: "ldbm_db_abort_rate"},
+	{ldapName: "nsslapd-db-txn-region-wait-rate", help: "Number of times a thread waited for the transaction region lock", kind: counterKind, label: "ldbm_db_txn_region_wait_rate"},
+	{ldapName: "nsslapd-db-log-bytes-since-checkpoint", help: "Bytes written to the transaction log since the last checkpoint", kind: gaugeKind, label: "ldbm_db_log_bytes_since_checkpoint"},
+	{ldapName: "nsslapd-db-log-region-wait-rate", help: "Number of times a thread waited for the log region lock", kind: counterKind, label: "ldbm_db_log_region_wait_rate"},
+	{ldapName: "nsslapd-db-log-write-rate", help: "Number of bytes written to the transaction log", kind: counterKind, label: "ldbm_db_log_write_rate"},
+
+	// LMDB environment
+	{ldapName: "dbenvmapmaxsize", help: "Configured maximum size of the LMDB memory map in bytes", kind: gaugeKind, label: "ldbm_dbenvmapmaxsize"},
+	{ldapName: "dbenvmapsize", help: "Current size of the LMDB memory map in bytes", kind: gaugeKind, label: "ldbm_dbenvmapsize"},
+	{ldapName: "dbenvlastpageno", help: "Last page number used in the LMDB memory map", kind: gaugeKind, label: "ldbm_dbenvlastpageno"},
+	{ldapName: "dbenvlasttxnid", help: "ID of the last committed LMDB transaction", kind: counterKind, label: "ldbm_dbenvlasttxnid"},
+	{ldapName: "dbenvmaxreaders", help: "Configured maximum number of LMDB readers", kind: gaugeKind, label: "ldbm_dbenvmaxreaders"},
+	{ldapName: "dbenvnumreaders", help: "Number of LMDB reader slots in use", kind: gaugeKind, label: "ldbm_dbenvnumreaders"},
+	{ldapName: "dbenvnumdbis", help: "Number of LMDB named databases", kind: gaugeKind, label: "ldbm_dbenvnumdbis"},
+	{ldapName: "waitingrwtxn", help: "Number of LMDB write transactions waiting to start", kind: gaugeKind, label: "ldbm_waitingrwtxn"},
+	{ldapName: "activerwtxn", help: "Number of active LMDB write transactions", kind: gaugeKind, label: "ldbm_activerwtxn"},
+	{ldapName: "abortrwtxn", help: "Number of aborted LMDB write transactions", kind: counterKind, label: "ldbm_abortrwtxn"},
+	{ldapName: "commitrwtxn", help: "Number of committed LMDB write transactions", kind: counterKind, label: "ldbm_commitrwtxn"},
+	{ldapName: "granttimerwtxn", help: "Cumulative time LMDB write transactions waited to start", kind: counterKind, label: "ldbm_granttimerwtxn"},
+	{ldapName: "lifetimerwtxn", help: "Cumulative lifetime of LMDB write transactions", kind: counterKind, label: "ldbm_lifetimerwtxn"},
+	{ldapName: "waitingrotxn", help: "Number of LMDB read transactions waiting to start", kind: gaugeKind, label: "ldbm_waitingrotxn"},
+	{ldapName: "activerotxn", help: "Number of active LMDB read transactions", kind: gaugeKind, label: "ldbm_activerotxn"},
+	{ldapName: "abortrotxn", help: "Number of aborted LMDB read transactions", kind: counterKind, label: "ldbm_abortrotxn"},
+	{ldapName: "commitrotxn", help: "Number of committed LMDB read transactions", kind: counterKind, label: "ldbm_commitrotxn"},
+	{ldapName: "granttimerotxn", help: "Cumulative time LMDB read transactions waited to start", kind: counterKind, label: "ldbm_granttimerotxn"},
+	{ldapName: "lifetimerotxn", help: "Cumulative lifetime of LMDB read transactions", kind: counterKind, label: "ldbm_lifetimerotxn"},
+}
+
+// ldbmCollector exports the global database cache, lock and transaction
+// statistics of the ldbm database plugin.
+type ldbmCollector struct {
+	descs    map[string]*prometheus.Desc
+	infoDesc *prometheus.Desc
+}
+
+func newLDBMCollector() *ldbmCollector {
+	return &ldbmCollector{
+		descs: newAttrDescs(ldbmMetricDefs, nil),
+		infoDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ldbm_info"),
+			"Database implementation of the ldbm database plugin (bdb or mdb)", []string{"implementation"}, nil,
+		),
+	}
+}
+
+func (c *ldbmCollector) describe(ch chan<- *prometheus.Desc) {
+	for _, m := range ldbmMetricDefs {
+		ch <- c.descs[m.ldapName]
+	}
+	ch <- c.infoDesc
+}
+
+func (c *ldbmCollector) collect(ctx context.Context, conn LDAPClient, timeout time.Duration, _ []*ldap.Entry, ch chan<- prometheus.Metric) error {
+	entries, err := searchSubtree(ctx, conn, ldbmMonitorDN, nil, timeout)
+	if err != nil {
+		return err
+	}
+
+	values := make(obj.Samples)
+	for _, e := range entries {
+		for k, v := range entryFloats(e) {
+			values[k] = v
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	emitAttrs(ch, ldbmMetricDefs, c.descs, values)
+	ch <- prometheus.MustNewConstMetric(c.infoDesc, prometheus.GaugeValue, 1, ldbmImplementation(values))
+	return nil
+}
+
+// ldbmImplementation tells LMDB from Berkeley DB by the presence of the LMDB
+// environment attributes.
+func ldbmImplementation(values obj.Samples) string {
+	if _, ok := values["dbenvmapmaxsize"]; ok {
+		return ldbmImplMDB
+	}
+	return ldbmImplBDB
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestLDBMCollector_BDB(t *testing.T) {
+	e := mockExporter(routeMock(map[string][]*ldap.Entry{
+		ldbmMonitorDN: {
+			{DN: ldbmMonitorDN, Attributes: []*ldap.EntryAttribute{
+				{Name: "dbcachehits", Values: []string{"700"}},
+				{Name: "dbcachetries", Values: []string{"800"}},
+				{Name: "dbcachehitratio", Values: []string{"87"}},
+				{Name: "dbcacheroevict", Values: []string{"3"}},
+			}},
+			{DN: "cn=database," + ldbmMonitorDN, Attributes: []*ldap.EntryAttribute{
+				{Name: "nsslapd-db-current-locks", Values: []string{"12"}},
+				{Name: "nsslapd-db-lock-conflicts", Values: []string{"5"}},
+			}},
+		},
+	}))
+
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{"ds_exporter_ldbm_dbcachehits", 700},
+		{"ds_exporter_ldbm_dbcachetries", 800},
+		{"ds_exporter_ldbm_dbcachehitratio", 87},
+		{"ds_exporter_ldbm_dbcacheroevict", 3},
+		{"ds_exporter_ldbm_db_current_locks", 12},
+		{"ds_exporter_ldbm_db_lock_conflicts", 5},
+	}
+	for _, tt := range tests {
+		if got, ok := gatherValue(t, e, tt.name, nil); !ok || got != tt.want {
+			t.Errorf("%s = %v (present %v), want %v", tt.name, got, ok, tt.want)
+		}
+	}
+	if _, ok := gatherValue(t, e, "ds_exporter_ldbm_info", map[string]string{"implementation": ldbmImplBDB}); !ok {
+		t.Error("expected ldbm_info{implementation=bdb}")
+	}
+	if _, ok := gatherValue(t, e, "ds_exporter_ldbm_dbenvmapsize", nil); ok {
+		t.Error("LMDB metrics should be absent on a Berkeley DB server")
+	}
+}
+
+func TestLDBMCollector_MDB(t *testing.T) {
+	e := mockExporter(routeMock(map[string][]*ldap.Entry{
+		ldbmMonitorDN: {
+			{DN: ldbmMonitorDN, Attributes: []*ldap.EntryAttribute{
+				{Name: "dbenvMapMaxSize", Values: []string{"21474836480"}},
+				{Name: "dbenvmapsize", Values: []string{"1048576"}},
+				{Name: "commitrwtxn", Values: []string{"42"}},
+				{Name: "dbcachehitratio", Values: []string{"99"}},
+			}},
+		},
+	}))
+
+	if v, _ := gatherValue(t, e, "ds_exporter_ldbm_dbenvmapmaxsize", nil); v != 21474836480 {
+		t.Errorf("dbenvmapmaxsize = %v", v)
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_ldbm_commitrwtxn", nil); v != 42 {
+		t.Errorf("commitrwtxn = %v, want 42", v)
+	}
+	if _, ok := gatherValue(t, e, "ds_exporter_ldbm_info", map[string]string{"implementation": ldbmImplMDB}); !ok {
+		t.Error("expected ldbm_info{implementation=mdb}")
+	}
+}
@@ -4,9 +4,12 @@ package main
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -23,20 +26,41 @@ const (
 )
 
 var (
-	port        int
-	server      string
-	_version    = "1.6"
-	ldapTimeout time.Duration
-	exporter    *Exporter
+	_version  = "1.6"
+	exporters []*Exporter
+
+	// modules holds the named connection settings available to /probe.
+	modules map[string]*module
+	// targets holds the targets declared in --config.file, by name.
+	targets map[string]scrapeTarget
 )
 
 func main() {
 	var (
 		listenAddress  = pflag.String("web.listen-address", ":9313", "Address to listen on for web interface and telemetry.")
 		metricsPath    = pflag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+		configFile     = pflag.String("config.file", "", "YAML file declaring modules and targets; its targets replace ldap.ServerFQDN for /metrics")
 		ldapServer     = pflag.String("ldap.ServerFQDN", "localhost", "FQDN of the target LDAP server")
 		ldapServerPort = pflag.Int("ldap.ServerPort", 389, "Port to connect on LDAP server")
 		timeout        = pflag.Duration("ldap.timeout", 10*time.Second, "LDAP connection timeout")
+		ldapPoolSize   = pflag.Int("ldap.poolSize", defaultPoolSize, "Number of connections kept open to each directory server")
+		ldapSocketPath = pflag.String("ldap.socket", "", "Path of an ldapi:// Unix socket to connect to instead of ldap.ServerFQDN:ldap.ServerPort")
+		ldapBindMethod = pflag.String("ldap.bindMethod", bindMethodSimple, "Bind method: simple, or external for SASL EXTERNAL (ldapi autobind or TLS client certificate)")
+		ldapBindDN     = pflag.String("ldap.bindDN", "", "DN to bind as before searching (anonymous if empty)")
+		ldapBindPWFile = pflag.String("ldap.bindPasswordFile", "", "File containing the bind password (default: $"+bindPasswordEnv+")")
+		tlsMode        = pflag.String("ldap.tls.mode", tlsModeNone, "TLS mode: none, ldaps or starttls")
+		tlsCAFile      = pflag.String("ldap.tls.caFile", "", "PEM CA bundle used to verify the server certificate (default: system roots)")
+		tlsCertFile    = pflag.String("ldap.tls.certFile", "", "PEM client certificate for mutual TLS")
+		tlsKeyFile     = pflag.String("ldap.tls.keyFile", "", "PEM private key for the client certificate")
+		tlsServerName  = pflag.String("ldap.tls.serverName", "", "Server name to verify the certificate against (default: ldap.ServerFQDN)")
+		tlsMinVersion  = pflag.String("ldap.tls.minVersion", "1.2", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+		tlsCheck       = pflag.StringSlice("ldap.tls.check", nil, "TLS listeners whose certificates to export: ldaps and/or starttls, each optionally followed by :port")
+		connBindDNs    = pflag.StringSlice("connections.bindDNs", nil, "Bind DNs to label connection counts with; others are counted as \"other\" (default: the most frequent ones)")
+		connTopBindDNs = pflag.Int("connections.topBindDNs", connectionLabels.TopN, "Number of most frequent bind DNs to label connection counts with when connections.bindDNs is empty")
+		discoveryOn    = pflag.Bool("discovery.enabled", false, "Export every numeric attribute under the discovery DNs (default: cn=monitor and the ldbm database monitor)")
+		accessLogPath  = pflag.String("accesslog.path", "", "389-DS access log to follow for operation latency histograms (disabled if empty)")
+		errorsLogPath  = pflag.String("errorslog.path", "", "389-DS errors log to follow for severity and subsystem counters (disabled if empty)")
+		pollInterval   = pflag.Duration("poll.interval", 0, "Poll the directory servers on this interval and serve cached snapshots to /metrics (0 searches at every scrape)")
 		showVersion    = pflag.BoolP("version", "v", false, "Show version information")
 		showHelp       = pflag.BoolP("help", "h", false, "Show help")
 	)
@@ -62,44 +86,215 @@ func main() {
 		log.Fatal("LDAP server cannot be empty")
 	}
 
-	port = *ldapServerPort
-	server = *ldapServer
-	ldapTimeout = *timeout
+	if *connTopBindDNs < 0 {
+		log.Fatal("Invalid connections.topBindDNs: must not be negative")
+	}
+	connectionLabels = connectionLabelSettings{BindDNs: *connBindDNs, TopN: *connTopBindDNs}
+
+	defaultModule := &module{
+		Timeout:    *timeout,
+		BindMethod: *ldapBindMethod,
+		BindDN:     *ldapBindDN,
+		TLS: tlsSettings{
+			Mode:       *tlsMode,
+			CAFile:     *tlsCAFile,
+			CertFile:   *tlsCertFile,
+			KeyFile:    *tlsKeyFile,
+			ServerName: *tlsServerName,
+			MinVersion: *tlsMinVersion,
+			Check:      *tlsCheck,
+		},
+	}
+	if *ldapBindDN != "" {
+		pw, err := readBindPassword(*ldapBindPWFile, bindPasswordEnv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if pw == "" {
+			log.Fatalf("Bind DN set but no password given: use --ldap.bindPasswordFile or $%s", bindPasswordEnv)
+		}
+		defaultModule.BindPassword = pw
+	}
+	if err := defaultModule.validate(); err != nil {
+		log.Fatalf("Invalid LDAP configuration: %v", err)
+	}
+	modules = map[string]*module{defaultModuleName: defaultModule}
+
+	var scrapeTargets []scrapeTarget
+	var discoveryConfig discoverySettings
+	if *configFile != "" {
+		cfg, err := loadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("Error loading config file %s: %v", *configFile, err)
+		}
+		modules, scrapeTargets, err = cfg.resolve(modules, *timeout)
+		if err != nil {
+			log.Fatalf("Invalid config file %s: %v", *configFile, err)
+		}
+		discoveryConfig = cfg.Discovery
+		if queries, err = buildQueries(cfg.Queries); err != nil {
+			log.Fatalf("Invalid config file %s: %v", *configFile, err)
+		}
+		if syntheticProbes, err = buildSyntheticProbes(cfg.Synthetic); err != nil {
+			log.Fatalf("Invalid config file %s: %v", *configFile, err)
+		}
+		if errorsLogRules, err = buildErrorsLogRules(cfg.ErrorsLog.Rules); err != nil {
+			log.Fatalf("Invalid config file %s: %v", *configFile, err)
+		}
+		for _, t := range scrapeTargets {
+			if err := t.checkLabels(); err != nil {
+				log.Fatalf("Invalid config file %s: target %q: %v", *configFile, t.Name, err)
+			}
+		}
+	}
+	if *discoveryOn || discoveryConfig.Enabled {
+		// Settings from the file are already validated; this only fills in
+		// the default DNs when discovery is enabled by flag alone.
+		_ = discoveryConfig.validate()
+		discovery = &discoveryConfig
+	}
+
+	if len(scrapeTargets) == 0 {
+		target := net.JoinHostPort(*ldapServer, strconv.Itoa(*ldapServerPort))
+		if *ldapSocketPath != "" {
+			target = *ldapSocketPath
+		}
+		targetURL, err := defaultModule.targetURL(target)
+		if err != nil {
+			log.Fatalf("Invalid LDAP target: %v", err)
+		}
+		scrapeTargets = []scrapeTarget{{URL: targetURL, Module: defaultModule}}
+	} else {
+		targets = make(map[string]scrapeTarget, len(scrapeTargets))
+		for _, t := range scrapeTargets {
+			targets[t.Name] = t
+		}
+	}
+
 	version.Version = _version
 
 	log.Println("Starting ds_exporter", version.Info())
 	log.Println("Build context", version.BuildContext())
-	log.Printf("Target LDAP Server: %s:%d (timeout: %v)", *ldapServer, port, *timeout)
+	if *pollInterval < 0 {
+		log.Fatal("Invalid poll.interval: must not be negative")
+	}
+	if *ldapPoolSize < 1 {
+		log.Fatal("Invalid ldap.poolSize: must be at least 1")
+	}
+	poolSize = *ldapPoolSize
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	defer stopPolling()
+	var pollers []*poller
+	var lagTargets []lagTarget
+	for _, t := range scrapeTargets {
+		log.Printf("Target LDAP Server: %s (timeout: %v)", t.URL, t.Module.Timeout)
+		if t.Module.TLS.Mode == tlsModeStartTLS {
+			log.Println("Using StartTLS")
+		}
+		if t.Module.BindMethod == bindMethodExternal {
+			log.Println("Binding with SASL EXTERNAL")
+		} else if t.Module.BindDN != "" {
+			log.Printf("Binding as %s", t.Module.BindDN)
+		}
 
-	exporter = NewExporter()
-	prometheus.MustRegister(exporter)
+		e := NewExporter(t.URL, t.Module)
+		reg := t.registerer(prometheus.DefaultRegisterer)
+		exporters = append(exporters, e)
+		var extra []contextCollector
+		if discovery != nil {
+			d := newDiscoveryCollector(e, discovery)
+			extra = append(extra, d)
+			discoverers = append(discoverers, d)
+		}
+		if *pollInterval > 0 {
+			p := newPoller(e, *pollInterval, extra...)
+			reg.MustRegister(p)
+			pollers = append(pollers, p)
+			go p.run(pollCtx)
+		} else {
+			liveCollectors = append(liveCollectors, liveCollector{target: t, c: e})
+			for _, c := range extra {
+				liveCollectors = append(liveCollectors, liveCollector{target: t, c: c})
+			}
+		}
+		lagTargets = append(lagTargets, lagTarget{name: t.Name, exporter: e})
+	}
+	if len(lagTargets) > 1 {
+		// When polling, the lag is computed from the RUVs of the snapshots,
+		// ignoring those the snapshot would report stale.
+		lag := newReplicationLagCollector(lagTargets, 2**pollInterval)
+		liveCollectors = append(liveCollectors, liveCollector{c: lag})
+	}
+
+	tailCtx, stopTailers := context.WithCancel(context.Background())
+	defer stopTailers()
+	if *accessLogPath != "" {
+		c := newAccessLogCollector()
+		prometheus.MustRegister(c)
+		go newTailer(*accessLogPath, c.handleLine).run(tailCtx)
+		log.Printf("Following access log %s", *accessLogPath)
+	}
+	if *errorsLogPath != "" {
+		c := newErrorsLogCollector(errorsLogRules)
+		prometheus.MustRegister(c)
+		go newTailer(*errorsLogPath, c.handleLine).run(tailCtx)
+		log.Printf("Following errors log %s", *errorsLogPath)
+	}
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, http.HandlerFunc(metricsHandler)))
+	reapCtx, stopReaping := context.WithCancel(context.Background())
+	defer stopReaping()
+	go reapProbeExporters(reapCtx)
+	http.HandleFunc("/probe", probeHandler)
+	http.HandleFunc("/debug/discovery", discoveryDebugHandler)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`<html>
              <head><title>389-DS Exporter</title></head>
              <body>
              <h1>389-DS Exporter</h1>
              <p>For the metrics: Click <a href='` + *metricsPath + `'>here</a></p>
+             <p>Multi-target probe: <a href='/probe?target=localhost:389'>/probe?target=host:port&amp;module=name</a></p>
              <p>Health check: <a href='/health'>here</a></p>
+             <p>Discovery report: <a href='/debug/discovery'>here</a></p>
              </body>
              </html>`))
 	})
 
-	// Health check endpoint — uses exporter's cached LDAP connection
+	// Health check endpoint — uses each exporter's cached LDAP connection,
+	// or in polling mode only the snapshots
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		conn, err := exporter.getLDAPConn()
-		if err != nil {
-			w.WriteHeader(http.StatusServiceUnavailable)
-			_, _ = w.Write([]byte("LDAP connection failed: " + err.Error()))
+		if len(pollers) > 0 {
+			for _, p := range pollers {
+				if err := p.health(); err != nil {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					_, _ = w.Write([]byte(err.Error()))
+					return
+				}
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("OK"))
 			return
 		}
-		_, err = searchLDAP(conn, ldapTimeout)
-		if err != nil {
-			exporter.closeLDAPConn()
-			w.WriteHeader(http.StatusServiceUnavailable)
-			_, _ = w.Write([]byte("LDAP search failed: " + err.Error()))
-			return
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+		for _, exporter := range exporters {
+			conn, err := exporter.getLDAPConn(ctx)
+			if err != nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				if errors.Is(err, errBind) || errors.Is(err, errTLS) {
+					_, _ = w.Write([]byte(err.Error()))
+					return
+				}
+				_, _ = w.Write([]byte("LDAP connection failed: " + err.Error()))
+				return
+			}
+			_, err = searchLDAP(ctx, conn, exporter.mod.Timeout)
+			exporter.releaseLDAPConn(conn, err)
+			if err != nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte("LDAP search failed on " + exporter.url + ": " + err.Error()))
+				return
+			}
 		}
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("OK"))
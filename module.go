@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultModuleName is the module built from command line flags. It is used
+// for /metrics and for /probe requests that do not name a module.
+const defaultModuleName = "default"
+
+// module is a named set of connection settings. Every target scraped with a
+// module shares its authentication, TLS and timeout configuration.
+type module struct {
+	Timeout      time.Duration
+	BindMethod   string
+	BindDN       string
+	BindPassword string
+	TLS          tlsSettings
+
+	tlsConfig  *tls.Config
+	certChecks []certCheck
+}
+
+// validate checks the module settings and prepares its TLS configuration.
+func (m *module) validate() error {
+	switch m.BindMethod {
+	case "", bindMethodSimple:
+		if m.BindDN != "" && m.BindPassword == "" {
+			return fmt.Errorf("bind DN %s set but no password given", m.BindDN)
+		}
+	case bindMethodExternal:
+		if m.BindDN != "" {
+			return fmt.Errorf("bind DN cannot be combined with bind method %s", bindMethodExternal)
+		}
+	default:
+		return fmt.Errorf("invalid bind method %q: must be %s or %s", m.BindMethod, bindMethodSimple, bindMethodExternal)
+	}
+
+	if m.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive, got %v", m.Timeout)
+	}
+
+	cfg, err := m.TLS.config()
+	if err != nil {
+		return fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+	checks, err := parseCertChecks(m.TLS.Check)
+	if err != nil {
+		return fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+	m.tlsConfig, m.certChecks = cfg, checks
+	return nil
+}
+
+// targetURL turns a target into an LDAP URL. Bare host:port targets get the
+// scheme implied by the module's TLS mode, absolute paths are treated as
+// ldapi:// sockets and full ldap://, ldaps:// and ldapi:// URLs are used as
+// given, as long as their scheme agrees with the TLS mode.
+func (m *module) targetURL(target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("empty target")
+	}
+
+	var u *url.URL
+	switch {
+	case strings.HasPrefix(target, "/"):
+		u = &url.URL{Scheme: "ldapi", Path: target}
+	case strings.Contains(target, "://"):
+		var err error
+		u, err = url.Parse(target)
+		if err != nil {
+			return "", fmt.Errorf("invalid target %q: %w", target, err)
+		}
+	default:
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return "", fmt.Errorf("invalid target %q: %w", target, err)
+		}
+		u = &url.URL{Scheme: m.TLS.scheme(), Host: target}
+	}
+
+	switch u.Scheme {
+	case "ldap", "ldaps":
+		if u.Host == "" {
+			return "", fmt.Errorf("invalid target %q: missing host", target)
+		}
+		// A plain ldap:// target would send the bind password of an LDAPS
+		// module in cleartext; StartTLS cannot run inside LDAPS.
+		if m.TLS.Mode == tlsModeLDAPS && u.Scheme != "ldaps" {
+			return "", fmt.Errorf("target %q must use ldaps:// with TLS mode %s", target, tlsModeLDAPS)
+		}
+		if m.TLS.Mode == tlsModeStartTLS && u.Scheme != "ldap" {
+			return "", fmt.Errorf("target %q must use ldap:// with TLS mode %s", target, tlsModeStartTLS)
+		}
+	case "ldapi":
+		if m.TLS.Mode != "" && m.TLS.Mode != tlsModeNone {
+			return "", fmt.Errorf("TLS cannot be used with ldapi target %q", target)
+		}
+	default:
+		return "", fmt.Errorf("invalid target %q: unsupported scheme %q", target, u.Scheme)
+	}
+	return u.String(), nil
+}
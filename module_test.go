@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestModuleTargetURL(t *testing.T) {
+	plain := &module{}
+	ldaps := &module{TLS: tlsSettings{Mode: tlsModeLDAPS}}
+	starttls := &module{TLS: tlsSettings{Mode: tlsModeStartTLS}}
+
+	tests := []struct {
+		name   string
+		mod    *module
+		target string
+		want   string
+	}{
+		{"host port", plain, "ds1.example.com:389", "ldap://ds1.example.com:389"},
+		{"host port ldaps", ldaps, "ds1.example.com:636", "ldaps://ds1.example.com:636"},
+		{"host port starttls", starttls, "ds1.example.com:389", "ldap://ds1.example.com:389"},
+		{"full url", plain, "ldaps://ds1.example.com:636", "ldaps://ds1.example.com:636"},
+		{"socket path", plain, "/run/slapd-example.socket", "ldapi:///run/slapd-example.socket"},
+		{"ldapi url", plain, "ldapi:///run/slapd.socket", "ldapi:///run/slapd.socket"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.mod.targetURL(tt.target)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("targetURL(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModuleTargetURL_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		mod    *module
+		target string
+	}{
+		{"empty", &module{}, ""},
+		{"missing port", &module{}, "ds1.example.com"},
+		{"bad scheme", &module{}, "http://ds1.example.com:389"},
+		{"tls over ldapi", &module{TLS: tlsSettings{Mode: tlsModeLDAPS}}, "/run/slapd.socket"},
+		{"ldap url with ldaps", &module{TLS: tlsSettings{Mode: tlsModeLDAPS}}, "ldap://ds1.example.com:389"},
+		{"ldaps url with starttls", &module{TLS: tlsSettings{Mode: tlsModeStartTLS}}, "ldaps://ds1.example.com:636"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.mod.targetURL(tt.target); err == nil {
+				t.Errorf("targetURL(%q) succeeded, want error", tt.target)
+			}
+		})
+	}
+}
+
+func TestModuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mod     module
+		wantErr bool
+	}{
+		{"anonymous", module{Timeout: time.Second}, false},
+		{"simple bind", module{Timeout: time.Second, BindDN: "cn=x", BindPassword: "pw"}, false},
+		{"external", module{Timeout: time.Second, BindMethod: bindMethodExternal}, false},
+		{"missing password", module{Timeout: time.Second, BindDN: "cn=x"}, true},
+		{"external with dn", module{Timeout: time.Second, BindMethod: bindMethodExternal, BindDN: "cn=x"}, true},
+		{"unknown method", module{Timeout: time.Second, BindMethod: "gssapi"}, true},
+		{"zero timeout", module{}, true},
+		{"bad tls", module{Timeout: time.Second, TLS: tlsSettings{Mode: "ssl"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.mod.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// monitorEntryDef declares the metrics read from one entry of the cn=monitor
+// subtree. Exporting a new attribute means adding a definition here; a new
+// entry only needs its own monitorEntryDef.
+type monitorEntryDef struct {
+	dn      string
+	metrics []attrMetricDef
+}
+
+var monitorEntryDefs = []monitorEntryDef{
+	{
+		dn: "cn=monitor",
+		metrics: []attrMetricDef{
+			{ldapName: "threads", help: "Number of Threads max configured", kind: gaugeKind, label: "threads"},
+			{ldapName: "readwaiters", help: "Current number of threads waiting to read data from a client", kind: gaugeKind, label: "readwaiters"},
+			{ldapName: "opsinitiated", help: "Current number of operations the server has initiated since it started", kind: counterKind, label: "opsinitiated"},
+			{ldapName: "opscompleted", help: "Current number of operations the server has completed since it started", kind: counterKind, label: "opscompleted"},
+			{ldapName: "dtablesize", help: "The number of file descriptors available to the directory. Essentially, this value shows how many additional concurrent connections can be serviced by the directory", kind: gaugeKind, label: "dtablesize"},
+			{ldapName: "currentconnections", help: "Number of connections currently open", kind: gaugeKind, label: "currentconnections"},
+			{ldapName: "totalconnections", help: "Number of connections the server has handled since it started", kind: counterKind, label: "totalconnections"},
+			{ldapName: "currentconnectionsatmaxthreads", help: "Number of connections currently using the maximum number of threads per connection", kind: gaugeKind, label: "currentconnectionsatmaxthreads"},
+			{ldapName: "maxthreadsperconnhits", help: "Number of times a connection hit the maximum number of threads per connection", kind: counterKind, label: "maxthreadsperconnhits"},
+			{ldapName: "entriessent", help: "Number of entries sent to clients since the server started", kind: counterKind, label: "entriessent"},
+			// cn=snmp,cn=monitor publishes bytessent as well.
+			{ldapName: "bytessent", help: "Number of bytes sent to clients since the server started, as published on cn=monitor", kind: counterKind, label: "monitor_bytessent"},
+		},
+	},
+	{
+		dn: "cn=snmp,cn=monitor",
+		metrics: []attrMetricDef{
+			{ldapName: "anonymousbinds", help: "Number of Anonymous Binds", kind: counterKind, label: "anonymousbinds"},
+			{ldapName: "unauthbinds", help: "Number of Unauth Binds", kind: counterKind, label: "unauthbinds"},
+			{ldapName: "simpleauthbinds", help: "Number of Simple Auth Binds", kind: counterKind, label: "simpleauthbinds"},
+			{ldapName: "strongauthbinds", help: "Number of Strong Auth Binds", kind: counterKind, label: "strongauthbinds"},
+			{ldapName: "bindsecurityerrors", help: "Number of Bind Security Errors", kind: counterKind, label: "bindsecurityerrors"},
+			{ldapName: "inops", help: "Number of All Requests", kind: counterKind, label: "inops"},
+			{ldapName: "readops", help: "Number of Read Operations", kind: counterKind, label: "readops"},
+			{ldapName: "compareops", help: "Number of Compare Operations", kind: counterKind, label: "compareops"},
+			{ldapName: "addentryops", help: "Number of Add Entry Operations", kind: counterKind, label: "addentryops"},
+			{ldapName: "removeentryops", help: "Number of Remove Entry Operations", kind: counterKind, label: "removeentryops"},
+			{ldapName: "modifyentryops", help: "Number of Modify Entry Operations", kind: counterKind, label: "modifyentryops"},
+			{ldapName: "modifyrdnops", help: "Number of Modify RDN Operations", kind: counterKind, label: "modifyrdnops"},
+			{ldapName: "listops", help: "Number of List Operations", kind: counterKind, label: "listops"},
+			{ldapName: "searchops", help: "Number of LDAP Search Requests", kind: counterKind, label: "searchops"},
+			{ldapName: "onelevelsearchops", help: "Number of one-level Search Requests", kind: counterKind, label: "onelevelsearchops"},
+			{ldapName: "wholesubtreesearchops", help: "Number of subtree-level Search Requests", kind: counterKind, label: "wholesubtreesearchops"},
+			{ldapName: "referrals", help: "Number of LDAP referrals", kind: counterKind, label: "referrals"},
+			{ldapName: "chainings", help: "Number of operations chained to other servers", kind: counterKind, label: "chainings"},
+			{ldapName: "securityerrors", help: "Number of Security Errors", kind: counterKind, label: "securityerrors"},
+			{ldapName: "errors", help: "Number of Errors", kind: counterKind, label: "errors"},
+			{ldapName: "connections", help: "Number of Connections in Open State at the sampling time", kind: gaugeKind, label: "connections"},
+			{ldapName: "connectionseq", help: "Total Number of Connections opened", kind: counterKind, label: "connectionseq"},
+			{ldapName: "connectionsinmaxthreads", help: "Number of connections that are currently in a max thread state", kind: gaugeKind, label: "connectionsinmaxthreads"},
+			{ldapName: "connectionsmaxthreadscount", help: "Number of connectionsmaxthreadscount", kind: gaugeKind, label: "connectionsmaxthreadscount"},
+			{ldapName: "bytesrecv", help: "Total number of bytes received", kind: counterKind, label: "bytesrecv"},
+			{ldapName: "bytessent", help: "Total number of bytes sent", kind: counterKind, label: "bytessent"},
+			{ldapName: "entriesreturned", help: "Number of Entries Returned", kind: counterKind, label: "entriesreturned"},
+			{ldapName: "referralsreturned", help: "Number of Referrals Returned", kind: counterKind, label: "referralsreturned"},
+			{ldapName: "masterentries", help: "Number of entries mastered by the server", kind: gaugeKind, label: "masterentries"},
+			{ldapName: "copyentries", help: "Number of entries the server holds as a replica", kind: gaugeKind, label: "copyentries"},
+			{ldapName: "cacheentries", help: "Number of Cache Entries", kind: gaugeKind, label: "cacheentries"},
+			{ldapName: "cachehits", help: "Number of Cache Hits", kind: counterKind, label: "cachehits"},
+			{ldapName: "slavehits", help: "Number of operations served from replicated entries", kind: counterKind, label: "slavehits"},
+		},
+	},
+}
+
+// monitorCollector exports the attributes declared in monitorEntryDefs from
+// the cn=monitor subtree already read by the exporter.
+type monitorCollector struct {
+	descs []map[string]*prometheus.Desc
+}
+
+func newMonitorCollector() *monitorCollector {
+	c := &monitorCollector{descs: make([]map[string]*prometheus.Desc, len(monitorEntryDefs))}
+	for i, def := range monitorEntryDefs {
+		c.descs[i] = newAttrDescs(def.metrics, nil)
+	}
+	return c
+}
+
+func (c *monitorCollector) describe(ch chan<- *prometheus.Desc) {
+	for i, def := range monitorEntryDefs {
+		for _, m := range def.metrics {
+			ch <- c.descs[i][m.ldapName]
+		}
+	}
+}
+
+func (c *monitorCollector) collect(ctx context.Context, _ LDAPClient, _ time.Duration, monitor []*ldap.Entry, ch chan<- prometheus.Metric) error {
+	data := parseMonitorAttrs(monitor)
+	for i, def := range monitorEntryDefs {
+		emitAttrs(ch, def.metrics, c.descs[i], data[strings.ToLower(def.dn)])
+	}
+	return nil
+}
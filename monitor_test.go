@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestMonitorCollector_ReadsDeclaredEntry(t *testing.T) {
+	c := collectorFunc{
+		c: newMonitorCollector(),
+		monitor: []*ldap.Entry{
+			{DN: "cn=monitor", Attributes: []*ldap.EntryAttribute{
+				{Name: "threads", Values: []string{"24"}},
+				{Name: "bytessent", Values: []string{"100"}},
+			}},
+			{DN: "cn=snmp,cn=monitor", Attributes: []*ldap.EntryAttribute{
+				{Name: "bytesSent", Values: []string{"200"}},
+				{Name: "threads", Values: []string{"99"}},
+			}},
+		},
+	}
+
+	if v, _ := gatherValue(t, c, "ds_exporter_threads", nil); v != 24 {
+		t.Errorf("threads = %v, want 24 from cn=monitor", v)
+	}
+	if v, _ := gatherValue(t, c, "ds_exporter_bytessent", nil); v != 200 {
+		t.Errorf("bytessent = %v, want 200 from cn=snmp,cn=monitor", v)
+	}
+	if v, _ := gatherValue(t, c, "ds_exporter_monitor_bytessent", nil); v != 100 {
+		t.Errorf("monitor_bytessent = %v, want 100 from cn=monitor", v)
+	}
+	if _, ok := gatherValue(t, c, "ds_exporter_cachehits", nil); ok {
+		t.Error("absent attribute should not be exported")
+	}
+}
+
+func TestMonitorEntryDefs_UniqueNames(t *testing.T) {
+	seen := make(map[string]string)
+	for _, def := range monitorEntryDefs {
+		for _, m := range def.metrics {
+			if prev, ok := seen[m.label]; ok {
+				t.Errorf("metric %s declared for both %s and %s", m.label, prev, def.dn)
+			}
+			seen[m.label] = def.dn
+		}
+	}
+}
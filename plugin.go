@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pluginsDN holds one entry of class nsslapdplugin per server plugin.
+const pluginsDN = "cn=plugins,cn=config"
+
+const pluginFilter = "(objectclass=nsslapdplugin)"
+
+var pluginAttrs = []string{
+	"cn",
+	"nsslapd-pluginenabled",
+	"nsslapd-plugintype",
+	"nsslapd-pluginid",
+	"nsslapd-pluginversion",
+	"nsslapd-pluginvendor",
+}
+
+// pluginCollector exports whether each server plugin is enabled, so a
+// plugin such as memberOf or referential integrity switched off by a config
+// change does not go unnoticed.
+type pluginCollector struct {
+	enabledDesc *prometheus.Desc
+	infoDesc    *prometheus.Desc
+}
+
+func newPluginCollector() *pluginCollector {
+	return &pluginCollector{
+		enabledDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "plugin_enabled"),
+			"Whether the plugin is enabled (1) or not (0), from nsslapd-pluginEnabled", []string{"plugin", "type"}, nil,
+		),
+		infoDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "plugin_info"),
+			"Identity and version of the plugin", []string{"plugin", "type", "id", "version", "vendor"}, nil,
+		),
+	}
+}
+
+func (c *pluginCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.enabledDesc
+	ch <- c.infoDesc
+}
+
+func (c *pluginCollector) collect(ctx context.Context, conn LDAPClient, timeout time.Duration, _ []*ldap.Entry, ch chan<- prometheus.Metric) error {
+	entries, err := searchOneLevel(ctx, conn, pluginsDN, pluginFilter, pluginAttrs, timeout)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		plugin := e.GetEqualFoldAttributeValue("cn")
+		if plugin == "" || seen[plugin] {
+			continue
+		}
+		seen[plugin] = true
+		typ := e.GetEqualFoldAttributeValue("nsslapd-plugintype")
+
+		if v := e.GetEqualFoldAttributeValue("nsslapd-pluginenabled"); v != "" {
+			enabled := 0.0
+			if strings.EqualFold(v, "on") {
+				enabled = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.enabledDesc, prometheus.GaugeValue, enabled, plugin, typ)
+		}
+		ch <- prometheus.MustNewConstMetric(c.infoDesc, prometheus.GaugeValue, 1,
+			plugin, typ,
+			e.GetEqualFoldAttributeValue("nsslapd-pluginid"),
+			e.GetEqualFoldAttributeValue("nsslapd-pluginversion"),
+			e.GetEqualFoldAttributeValue("nsslapd-pluginvendor"),
+		)
+	}
+	return nil
+}
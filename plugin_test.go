@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func pluginEntry(name, enabled, typ, version string) *ldap.Entry {
+	return &ldap.Entry{
+		DN: "cn=" + name + "," + pluginsDN,
+		Attributes: []*ldap.EntryAttribute{
+			{Name: "cn", Values: []string{name}},
+			{Name: "nsslapd-pluginEnabled", Values: []string{enabled}},
+			{Name: "nsslapd-pluginType", Values: []string{typ}},
+			{Name: "nsslapd-pluginId", Values: []string{"memberof"}},
+			{Name: "nsslapd-pluginVersion", Values: []string{version}},
+			{Name: "nsslapd-pluginVendor", Values: []string{"389 Project"}},
+		},
+	}
+}
+
+func TestPluginCollector(t *testing.T) {
+	e := mockExporter(routeMock(map[string][]*ldap.Entry{
+		pluginsDN: {
+			pluginEntry("MemberOf Plugin", "on", "betxnpostoperation", "2.4.5"),
+			pluginEntry("referential integrity postoperation", "off", "betxnpostoperation", "2.4.5"),
+		},
+	}))
+
+	tests := []struct {
+		plugin string
+		want   float64
+	}{
+		{"MemberOf Plugin", 1},
+		{"referential integrity postoperation", 0},
+	}
+	for _, tt := range tests {
+		labels := map[string]string{"plugin": tt.plugin, "type": "betxnpostoperation"}
+		if v, ok := gatherValue(t, e, "ds_exporter_plugin_enabled", labels); !ok || v != tt.want {
+			t.Errorf("%s enabled = %v (present %v), want %v", tt.plugin, v, ok, tt.want)
+		}
+	}
+	labels := map[string]string{"plugin": "MemberOf Plugin", "id": "memberof", "version": "2.4.5", "vendor": "389 Project"}
+	if v, ok := gatherValue(t, e, "ds_exporter_plugin_info", labels); !ok || v != 1 {
+		t.Errorf("plugin_info = %v (present %v), want 1", v, ok)
+	}
+}
+
+func TestPluginCollector_SearchesOneLevel(t *testing.T) {
+	var got *ldap.SearchRequest
+	conn := &mockLDAP{
+		searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			got = req
+			return &ldap.SearchResult{}, nil
+		},
+	}
+
+	if err := newPluginCollector().collect(context.Background(), conn, time.Second, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got.BaseDN != pluginsDN || got.Scope != ldap.ScopeSingleLevel || got.Filter != pluginFilter {
+		t.Errorf("searched %q scope %d filter %q, want the children of %s", got.BaseDN, got.Scope, got.Filter, pluginsDN)
+	}
+	if len(got.Attributes) != len(pluginAttrs) {
+		t.Errorf("requested attributes %v, want %v", got.Attributes, pluginAttrs)
+	}
+}
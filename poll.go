@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poller collects an exporter on its own interval and serves the last
+// snapshot to scrapes, so any number of Prometheus servers cost the
+// directory one search per interval and a scrape never waits on LDAP.
+// Collectors in extra, such as the discovery collector of the target, are
+// snapshotted along with the exporter.
+type poller struct {
+	e        *Exporter
+	extra    []contextCollector
+	interval time.Duration
+	now      func() time.Time
+
+	mu       sync.Mutex
+	metrics  []prometheus.Metric
+	taken    time.Time
+	up       bool
+	lastErr  error
+	failures int
+
+	ageDesc      *prometheus.Desc
+	failuresDesc *prometheus.Desc
+	staleDesc    *prometheus.Desc
+}
+
+func newPoller(e *Exporter, interval time.Duration, extra ...contextCollector) *poller {
+	return &poller{
+		e:        e,
+		extra:    extra,
+		interval: interval,
+		now:      time.Now,
+		ageDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "snapshot_age_seconds"),
+			"Time since the served snapshot of the directory server was taken", nil, nil,
+		),
+		failuresDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "snapshot_consecutive_failures"),
+			"Number of polls in a row that hit an error", nil, nil,
+		),
+		staleDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "snapshot_stale"),
+			"Whether the served snapshot is older than two poll intervals, or none was taken yet (1) or not (0)", nil, nil,
+		),
+	}
+}
+
+func (p *poller) Describe(ch chan<- *prometheus.Desc) {
+	p.e.Describe(ch)
+	for _, c := range p.extra {
+		c.Describe(ch)
+	}
+	p.describeSnapshot(ch)
+}
+
+// describeSnapshot sends the descriptors of the poller's own metrics.
+func (p *poller) describeSnapshot(ch chan<- *prometheus.Desc) {
+	ch <- p.ageDesc
+	ch <- p.failuresDesc
+	ch <- p.staleDesc
+}
+
+// Collect serves the last snapshot, however old, and flags it stale once
+// polling has fallen behind.
+func (p *poller) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, m := range p.metrics {
+		ch <- m
+	}
+	stale := 1.0
+	if !p.taken.IsZero() {
+		age := p.now().Sub(p.taken)
+		ch <- prometheus.MustNewConstMetric(p.ageDesc, prometheus.GaugeValue, age.Seconds())
+		if age <= 2*p.interval {
+			stale = 0
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(p.failuresDesc, prometheus.GaugeValue, float64(p.failures))
+	ch <- prometheus.MustNewConstMetric(p.staleDesc, prometheus.GaugeValue, stale)
+}
+
+// poll takes a new snapshot, its LDAP operations bounded by ctx.
+func (p *poller) poll(ctx context.Context) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+	up, err := p.e.collect(ctx, ch)
+	for _, c := range p.extra {
+		c.CollectContext(ctx, ch)
+	}
+	close(ch)
+	metrics := <-done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metrics, p.taken, p.up, p.lastErr = metrics, p.now(), up, err
+	if err != nil {
+		p.failures++
+	} else {
+		p.failures = 0
+	}
+}
+
+// run polls immediately and then every interval until ctx is done.
+func (p *poller) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// health reports why the snapshot cannot vouch for the server: none taken
+// yet, too old, or the last poll could not read cn=monitor.
+func (p *poller) health() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.taken.IsZero():
+		return fmt.Errorf("no snapshot of %s taken yet", p.e.url)
+	case p.now().Sub(p.taken) > 2*p.interval:
+		return fmt.Errorf("snapshot of %s is stale, taken %s", p.e.url, p.taken.Format(time.RFC3339))
+	case !p.up:
+		return fmt.Errorf("last poll of %s failed: %v", p.e.url, p.lastErr)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// countingMock serves the cn=monitor subtree, or fails when fail is set, and
+// counts the searches it answers.
+func countingMock(searches *atomic.Int32, fail *atomic.Bool) *mockLDAP {
+	routed := routeMock(map[string][]*ldap.Entry{
+		"cn=monitor": monitorTestEntries(func(int) string { return "1" }),
+	})
+	return &mockLDAP{
+		searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			searches.Add(1)
+			if fail.Load() {
+				return nil, errors.New("server is down")
+			}
+			return routed.searchFunc(req)
+		},
+		closeFunc: func() error { return nil },
+	}
+}
+
+func TestPoller_ServesSnapshot(t *testing.T) {
+	var searches atomic.Int32
+	var fail atomic.Bool
+	e := mockExporter(countingMock(&searches, &fail))
+	p := newPoller(e, time.Minute)
+	now := time.Unix(1700000000, 0)
+	p.now = func() time.Time { return now }
+
+	if v, ok := gatherValue(t, p, "ds_exporter_snapshot_stale", nil); !ok || v != 1 {
+		t.Errorf("stale before the first poll = %v (present %v), want 1", v, ok)
+	}
+	if _, ok := gatherValue(t, p, "ds_exporter_up", nil); ok {
+		t.Error("up should be absent before the first poll")
+	}
+	if err := p.health(); err == nil {
+		t.Error("health should fail before the first poll")
+	}
+
+	p.poll(context.Background())
+	polled := searches.Load()
+	now = now.Add(30 * time.Second)
+
+	for _, tt := range []struct {
+		name string
+		want float64
+	}{
+		{"ds_exporter_up", 1},
+		{"ds_exporter_threads", 1},
+		{"ds_exporter_snapshot_age_seconds", 30},
+		{"ds_exporter_snapshot_stale", 0},
+		{"ds_exporter_snapshot_consecutive_failures", 0},
+	} {
+		if v, ok := gatherValue(t, p, tt.name, nil); !ok || v != tt.want {
+			t.Errorf("%s = %v (present %v), want %v", tt.name, v, ok, tt.want)
+		}
+	}
+	if got := searches.Load(); got != polled {
+		t.Errorf("scrapes searched the directory %d times, want 0", got-polled)
+	}
+	if err := p.health(); err != nil {
+		t.Errorf("health = %v, want nil", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if v, _ := gatherValue(t, p, "ds_exporter_snapshot_stale", nil); v != 1 {
+		t.Errorf("stale after two intervals = %v, want 1", v)
+	}
+	if v, _ := gatherValue(t, p, "ds_exporter_up", nil); v != 1 {
+		t.Errorf("a stale snapshot should still be served, up = %v", v)
+	}
+	if err := p.health(); err == nil || !strings.Contains(err.Error(), "stale") {
+		t.Errorf("health = %v, want stale error", err)
+	}
+}
+
+func TestPoller_ConsecutiveFailures(t *testing.T) {
+	var searches atomic.Int32
+	var fail atomic.Bool
+	p := newPoller(mockExporter(countingMock(&searches, &fail)), time.Minute)
+
+	fail.Store(true)
+	p.poll(context.Background())
+	p.poll(context.Background())
+	if v, _ := gatherValue(t, p, "ds_exporter_snapshot_consecutive_failures", nil); v != 2 {
+		t.Errorf("consecutive failures = %v, want 2", v)
+	}
+	if v, _ := gatherValue(t, p, "ds_exporter_up", nil); v != 0 {
+		t.Errorf("up = %v, want 0", v)
+	}
+	if err := p.health(); err == nil || !strings.Contains(err.Error(), "server is down") {
+		t.Errorf("health = %v, want the poll error", err)
+	}
+
+	fail.Store(false)
+	p.poll(context.Background())
+	if v, _ := gatherValue(t, p, "ds_exporter_snapshot_consecutive_failures", nil); v != 0 {
+		t.Errorf("consecutive failures after recovery = %v, want 0", v)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// defaultPoolSize is the number of connections a target may have open
+	// when --ldap.poolSize is not given.
+	defaultPoolSize = 2
+	// poolHealthCheckIdle is how long a connection may sit idle before it is
+	// checked with a root DSE search on reuse.
+	poolHealthCheckIdle = 30 * time.Second
+
+	// breakerThreshold consecutive failures open the circuit breaker.
+	breakerThreshold = 3
+	// The breaker stays open for breakerMinBackoff after opening, doubled
+	// each time a half-open trial fails, up to breakerMaxBackoff.
+	breakerMinBackoff = time.Second
+	breakerMaxBackoff = 5 * time.Minute
+)
+
+// poolSize is the size of the connection pool of every target.
+var poolSize = defaultPoolSize
+
+// errCircuitOpen is returned without contacting the server while the
+// circuit breaker of a target is open.
+var errCircuitOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStates = []breakerState{breakerClosed, breakerOpen, breakerHalfOpen}
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+type idleConn struct {
+	c     LDAPClient
+	since time.Time
+}
+
+// connPool keeps up to size connections to one target and a circuit
+// breaker in front of them. Connections are taken with get and handed back
+// with release, which reports whether the connection broke.
+//
+// The breaker opens after breakerThreshold consecutive failures, dial
+// failures and broken connections alike, and then fails every get at once
+// until its backoff has passed. A single trial is then let through: if its
+// connection is released intact the breaker closes, otherwise it opens
+// again with twice the backoff.
+type connPool struct {
+	dial    func(ctx context.Context) (LDAPClient, error)
+	size    int
+	timeout time.Duration
+	now     func() time.Time
+
+	// slots holds one token per connection in use.
+	slots chan struct{}
+
+	mu        sync.Mutex
+	idle      []idleConn
+	inUse     int
+	state     breakerState
+	failures  int
+	backoff   time.Duration
+	openUntil time.Time
+	trial     bool
+	opened    int
+	lastErr   error
+}
+
+// newConnPool returns a pool of size connections made by dial. timeout
+// bounds the health checks.
+func newConnPool(size int, timeout time.Duration, dial func(ctx context.Context) (LDAPClient, error)) *connPool {
+	if size < 1 {
+		size = 1
+	}
+	return &connPool{
+		dial:    dial,
+		size:    size,
+		timeout: timeout,
+		now:     time.Now,
+		slots:   make(chan struct{}, size),
+	}
+}
+
+// get returns an idle connection, checking it first if it sat idle for a
+// while, or dials a new one. It waits, bounded by ctx, while all
+// connections are in use.
+func (p *connPool) get(ctx context.Context) (LDAPClient, error) {
+	if err := p.admit(); err != nil {
+		return nil, err
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		p.abandonTrial()
+		return nil, ctx.Err()
+	}
+
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		ic := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.inUse++
+		p.mu.Unlock()
+
+		if p.now().Sub(ic.since) < poolHealthCheckIdle {
+			return ic.c, nil
+		}
+		err := checkConn(ctx, ic.c, p.timeout)
+		if err == nil {
+			return ic.c, nil
+		}
+		log.Printf("Dropping idle LDAP connection that failed its health check: %v", err)
+		_ = ic.c.Close()
+		p.mu.Lock()
+		p.inUse--
+		p.mu.Unlock()
+	}
+
+	c, err := p.dial(ctx)
+	if err != nil {
+		<-p.slots
+		p.recordFailure(err)
+		return nil, err
+	}
+	p.mu.Lock()
+	p.inUse++
+	p.mu.Unlock()
+	return c, nil
+}
+
+// release hands c back. A non-nil err means c broke: it is closed and
+// counts as a failure of the target. An intact connection is kept for
+// reuse and counts as a success.
+func (p *connPool) release(c LDAPClient, err error) {
+	p.mu.Lock()
+	p.inUse--
+	if err == nil && p.state != breakerOpen {
+		p.idle = append(p.idle, idleConn{c: c, since: p.now()})
+		c = nil
+	}
+	p.mu.Unlock()
+	<-p.slots
+
+	if c != nil {
+		_ = c.Close()
+	}
+	if err != nil {
+		p.recordFailure(err)
+	} else {
+		p.recordSuccess()
+	}
+}
+
+// close closes the idle connections. Connections in use are closed when
+// they are released broken, or kept otherwise.
+func (p *connPool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, ic := range idle {
+		_ = ic.c.Close()
+	}
+}
+
+// admit fails fast while the breaker is open, and lets a single trial
+// through once its backoff has passed.
+func (p *connPool) admit() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch p.state {
+	case breakerOpen:
+		if p.now().Before(p.openUntil) {
+			return fmt.Errorf("%w until %s after %d failures, last: %v",
+				errCircuitOpen, p.openUntil.Format(time.RFC3339), p.failures, p.lastErr)
+		}
+		p.state = breakerHalfOpen
+		p.trial = true
+	case breakerHalfOpen:
+		if p.trial {
+			return fmt.Errorf("%w, waiting for the trial connection", errCircuitOpen)
+		}
+		p.trial = true
+	}
+	return nil
+}
+
+// abandonTrial frees the half-open trial of a get that gave up before
+// reaching the server.
+func (p *connPool) abandonTrial() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == breakerHalfOpen {
+		p.trial = false
+	}
+}
+
+func (p *connPool) recordSuccess() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state, p.failures, p.backoff, p.trial, p.lastErr = breakerClosed, 0, 0, false, nil
+}
+
+func (p *connPool) recordFailure(err error) {
+	p.mu.Lock()
+	p.failures++
+	p.lastErr = err
+	p.trial = false
+	if p.state != breakerHalfOpen && p.failures < breakerThreshold {
+		p.mu.Unlock()
+		return
+	}
+	if p.backoff == 0 {
+		p.backoff = breakerMinBackoff
+	} else {
+		p.backoff = min(2*p.backoff, breakerMaxBackoff)
+	}
+	p.openUntil = p.now().Add(p.backoff)
+	if p.state == breakerClosed {
+		p.opened++
+		log.Printf("Circuit breaker open for %v after %d failures: %v", p.backoff, p.failures, err)
+	}
+	p.state = breakerOpen
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, ic := range idle {
+		_ = ic.c.Close()
+	}
+}
+
+// stats returns the breaker state, how often the breaker opened, and the
+// number of idle and in-use connections.
+func (p *connPool) stats() (state breakerState, opened, idle, inUse int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state, p.opened, len(p.idle), p.inUse
+}
+
+// checkConn reads the root DSE to tell whether c still works.
+func checkConn(ctx context.Context, c LDAPClient, timeout time.Duration) error {
+	req := ldap.NewSearchRequest(
+		"",
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectclass=*)",
+		[]string{"1.1"},
+		nil,
+	)
+	_, err := runLDAP(ctx, c, timeout, func() (*ldap.SearchResult, error) {
+		return c.Search(req)
+	})
+	return err
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testPool returns a pool of size connections dialled by dial, with a clock
+// the test moves by hand.
+func testPool(size int, dial func(ctx context.Context) (LDAPClient, error)) (*connPool, *time.Time) {
+	p := newConnPool(size, time.Second, dial)
+	now := time.Unix(1700000000, 0)
+	p.now = func() time.Time { return now }
+	return p, &now
+}
+
+func TestConnPool_BreakerBacksOff(t *testing.T) {
+	dials := 0
+	dialErr := errors.New("connection refused")
+	p, now := testPool(2, func(context.Context) (LDAPClient, error) {
+		dials++
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		return &mockLDAP{closeFunc: func() error { return nil }}, nil
+	})
+	ctx := context.Background()
+
+	for i := range breakerThreshold {
+		if _, err := p.get(ctx); err == nil || errors.Is(err, errCircuitOpen) {
+			t.Fatalf("get %d: err = %v, want the dial error", i+1, err)
+		}
+	}
+	if state, opened, _, _ := p.stats(); state != breakerOpen || opened != 1 {
+		t.Fatalf("state %v, opened %d after %d failures; want open, 1", state, opened, breakerThreshold)
+	}
+	if _, err := p.get(ctx); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("err = %v while open, want errCircuitOpen", err)
+	}
+	if dials != breakerThreshold {
+		t.Errorf("dialled %d times, an open breaker should not dial", dials)
+	}
+
+	// The failed trial doubles the backoff.
+	*now = now.Add(breakerMinBackoff)
+	if _, err := p.get(ctx); err == nil || errors.Is(err, errCircuitOpen) {
+		t.Fatalf("trial err = %v, want the dial error", err)
+	}
+	*now = now.Add(breakerMinBackoff)
+	if _, err := p.get(ctx); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("err = %v before the doubled backoff passed, want errCircuitOpen", err)
+	}
+
+	// A trial that succeeds closes the breaker.
+	*now = now.Add(breakerMinBackoff)
+	dialErr = nil
+	c, err := p.get(ctx)
+	if err != nil {
+		t.Fatalf("trial err = %v", err)
+	}
+	if state, _, _, _ := p.stats(); state != breakerHalfOpen {
+		t.Errorf("state during the trial = %v, want half_open", state)
+	}
+	if _, err := p.get(ctx); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("second get during the trial: err = %v, want errCircuitOpen", err)
+	}
+	p.release(c, nil)
+	if state, opened, _, _ := p.stats(); state != breakerClosed || opened != 1 {
+		t.Errorf("state %v, opened %d after the trial; want closed, 1", state, opened)
+	}
+}
+
+func TestConnPool_BrokenConnectionsOpenBreaker(t *testing.T) {
+	closes := 0
+	p, _ := testPool(1, func(context.Context) (LDAPClient, error) {
+		return &mockLDAP{closeFunc: func() error { closes++; return nil }}, nil
+	})
+
+	for range breakerThreshold {
+		c, err := p.get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.release(c, errors.New("connection reset"))
+	}
+	if closes != breakerThreshold {
+		t.Errorf("closed %d broken connections, want %d", closes, breakerThreshold)
+	}
+	if state, _, idle, inUse := p.stats(); state != breakerOpen || idle != 0 || inUse != 0 {
+		t.Errorf("state %v with %d idle and %d in use, want open and empty", state, idle, inUse)
+	}
+}
+
+func TestConnPool_HealthCheck(t *testing.T) {
+	healthy := true
+	dials := 0
+	p, now := testPool(1, func(context.Context) (LDAPClient, error) {
+		dials++
+		return &mockLDAP{
+			searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+				if req.BaseDN != "" {
+					t.Errorf("health check searched %q, want the root DSE", req.BaseDN)
+				}
+				if !healthy {
+					return nil, errors.New("connection reset")
+				}
+				return &ldap.SearchResult{}, nil
+			},
+			closeFunc: func() error { return nil },
+		}, nil
+	})
+	ctx := context.Background()
+
+	c, _ := p.get(ctx)
+	p.release(c, nil)
+	*now = now.Add(poolHealthCheckIdle)
+	if c2, _ := p.get(ctx); c2 != c {
+		t.Error("a healthy idle connection should be reused")
+	} else {
+		p.release(c2, nil)
+	}
+
+	healthy = false
+	*now = now.Add(poolHealthCheckIdle)
+	c3, err := p.get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c3 == c || dials != 2 {
+		t.Errorf("dials = %d, an idle connection failing its check should be replaced", dials)
+	}
+}
+
+func TestConnPool_WaitsForFreeConnection(t *testing.T) {
+	p, _ := testPool(1, func(context.Context) (LDAPClient, error) {
+		return &mockLDAP{closeFunc: func() error { return nil }}, nil
+	})
+
+	c, err := p.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v with the pool exhausted, want a deadline error", err)
+	}
+
+	p.release(c, nil)
+	if c2, err := p.get(context.Background()); err != nil || c2 != c {
+		t.Errorf("get after release = %v, %v; want the released connection", c2, err)
+	}
+}
+
+func TestExporter_CircuitBreakerMetrics(t *testing.T) {
+	e := NewExporter("ldap://ldap.example.com:389", testModule())
+	e.dial = func(context.Context, string) (LDAPClient, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	for range breakerThreshold {
+		gatherValue(t, e, "ds_exporter_up", nil)
+	}
+	for _, tt := range []struct {
+		state string
+		want  float64
+	}{{"closed", 0}, {"open", 1}, {"half_open", 0}} {
+		if v, ok := gatherValue(t, e, "ds_exporter_circuit_breaker_state", map[string]string{"state": tt.state}); !ok || v != tt.want {
+			t.Errorf("state %s = %v (present %v), want %v", tt.state, v, ok, tt.want)
+		}
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_circuit_breaker_opened_total", nil); v != 1 {
+		t.Errorf("opened_total = %v, want 1", v)
+	}
+	// The open breaker does not count as a dial failure.
+	if v, _ := gatherValue(t, e, "ds_exporter_scrape_failures_total", map[string]string{"stage": stageDial}); v != breakerThreshold {
+		t.Errorf("dial failures = %v, want %d", v, breakerThreshold)
+	}
+}
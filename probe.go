@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeHandler scrapes a single target given in the query string, in the
+// style of the blackbox exporter:
+//
+//	/probe?target=host:port&module=name
+//
+// Each request gets its own registry, so one exporter can serve every
+// directory server in a topology. The collector of a target and module is
+// kept between requests so its connection pool and circuit breaker carry
+// over. target may also name a target from --config.file, which is then
+// probed with its own settings; other targets are refused for modules with
+// a bind password.
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	name := params.Get("module")
+
+	// A configured target can be probed by name with its own settings.
+	st, configured := targets[target]
+	if !configured || name != "" {
+		configured = false
+		if name == "" {
+			name = defaultModuleName
+		}
+		mod, ok := modules[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown module %q", name), http.StatusBadRequest)
+			return
+		}
+		// The caller picks the host, so the module's bind password would
+		// be sent wherever it asks.
+		if mod.BindPassword != "" {
+			http.Error(w, fmt.Sprintf("module %q has a bind password and only probes the targets of the configuration file", name), http.StatusForbidden)
+			return
+		}
+		u, err := mod.targetURL(target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		st = scrapeTarget{URL: u, Module: mod}
+	}
+
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	e, done := probeExporter(st, configured)
+	defer done()
+
+	registry := prometheus.NewRegistry()
+	reg := st.registerer(registry)
+	reg.MustRegister(scrapeCollector{e, ctx})
+	if discovery != nil {
+		reg.MustRegister(scrapeCollector{newDiscoveryCollector(e, discovery), ctx})
+	}
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// probeExporterIdle is how long the exporter of a probed target is kept,
+// with its idle connections, after its last probe.
+const probeExporterIdle = 10 * time.Minute
+
+// probeExportersMax bounds the exporters kept for /probe, and so the
+// connections they hold open, whatever targets callers ask for.
+const probeExportersMax = 64
+
+type probeKey struct {
+	url        string
+	mod        *module
+	configured bool
+}
+
+type probeEntry struct {
+	e        *Exporter
+	inFlight int
+	lastUsed time.Time
+}
+
+var (
+	probeMu        sync.Mutex
+	probeExporters = make(map[probeKey]*probeEntry)
+)
+
+// probeExporter returns the exporter of st shared by all its probes, and a
+// function to call once the probe is done. Only configured targets run the
+// synthetic probes. Once probeExportersMax exporters are kept the least
+// recently used idle one is closed; if all are busy the probe gets an
+// exporter of its own, closed when it is done.
+func probeExporter(st scrapeTarget, configured bool) (*Exporter, func()) {
+	probeMu.Lock()
+	defer probeMu.Unlock()
+
+	k := probeKey{url: st.URL, mod: st.Module, configured: configured}
+	pe, ok := probeExporters[k]
+	if !ok {
+		var probes []*syntheticProbe
+		if configured {
+			probes = syntheticProbes
+		}
+		e := newExporter(st.URL, st.Module, probes)
+		if len(probeExporters) >= probeExportersMax && !evictLRUProbeExporter() {
+			return e, e.closeLDAPConn
+		}
+		pe = &probeEntry{e: e}
+		probeExporters[k] = pe
+	}
+	pe.inFlight++
+	pe.lastUsed = time.Now()
+	return pe.e, func() {
+		probeMu.Lock()
+		defer probeMu.Unlock()
+		pe.inFlight--
+		pe.lastUsed = time.Now()
+	}
+}
+
+// evictLRUProbeExporter closes the least recently used exporter not serving
+// a probe, reporting whether there was one. probeMu must be held.
+func evictLRUProbeExporter() bool {
+	var oldest probeKey
+	var found *probeEntry
+	for k, pe := range probeExporters {
+		if pe.inFlight == 0 && (found == nil || pe.lastUsed.Before(found.lastUsed)) {
+			oldest, found = k, pe
+		}
+	}
+	if found == nil {
+		return false
+	}
+	found.e.closeLDAPConn()
+	delete(probeExporters, oldest)
+	return true
+}
+
+// reapProbeExporters closes the exporters not probed for probeExporterIdle,
+// checking every minute until ctx is done.
+func reapProbeExporters(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			reapIdleProbeExporters(now)
+		}
+	}
+}
+
+func reapIdleProbeExporters(now time.Time) {
+	probeMu.Lock()
+	defer probeMu.Unlock()
+	for k, pe := range probeExporters {
+		if pe.inFlight == 0 && now.Sub(pe.lastUsed) > probeExporterIdle {
+			pe.e.closeLDAPConn()
+			delete(probeExporters, k)
+		}
+	}
+}
+
+// liveCollector is a /metrics collector that searches the directory when
+// scraped.
+type liveCollector struct {
+	target scrapeTarget
+	c      contextCollector
+}
+
+// liveCollectors are registered anew for every /metrics request so that the
+// request's context bounds their LDAP operations.
+var liveCollectors []liveCollector
+
+// metricsHandler serves /metrics: the process-wide metrics of the default
+// registry plus the live collectors of every target.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	for _, l := range liveCollectors {
+		l.target.registerer(registry).MustRegister(scrapeCollector{l.c, ctx})
+	}
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// scrapeContext returns the context of a scrape: the request's, cancelled
+// when the client goes away, and bounded by the scrape timeout Prometheus
+// announces in X-Prometheus-Scrape-Timeout-Seconds.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+			return context.WithTimeout(r.Context(), time.Duration(secs*float64(time.Second)))
+		}
+	}
+	return context.WithCancel(r.Context())
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func withModules(t *testing.T, m map[string]*module) {
+	t.Helper()
+	orig := modules
+	modules = m
+	t.Cleanup(func() { modules = orig })
+}
+
+func withTargets(t *testing.T, m map[string]scrapeTarget) {
+	t.Helper()
+	orig := targets
+	targets = m
+	t.Cleanup(func() { targets = orig })
+}
+
+// withProbeExporters gives the test an empty cache of probe exporters and
+// closes the ones it created.
+func withProbeExporters(t *testing.T) {
+	t.Helper()
+	probeMu.Lock()
+	orig := probeExporters
+	probeExporters = make(map[probeKey]*probeEntry)
+	probeMu.Unlock()
+	t.Cleanup(func() {
+		probeMu.Lock()
+		defer probeMu.Unlock()
+		for _, pe := range probeExporters {
+			pe.e.closeLDAPConn()
+		}
+		probeExporters = orig
+	})
+}
+
+func doProbe(query url.Values) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	probeHandler(rec, httptest.NewRequest(http.MethodGet, "/probe?"+query.Encode(), nil))
+	return rec
+}
+
+func TestProbeHandler_MissingTarget(t *testing.T) {
+	withModules(t, map[string]*module{defaultModuleName: testModule()})
+
+	rec := doProbe(url.Values{})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestProbeHandler_UnknownModule(t *testing.T) {
+	withModules(t, map[string]*module{defaultModuleName: testModule()})
+
+	rec := doProbe(url.Values{"target": {"ds1:389"}, "module": {"nope"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestProbeHandler_InvalidTarget(t *testing.T) {
+	withModules(t, map[string]*module{defaultModuleName: testModule()})
+
+	rec := doProbe(url.Values{"target": {"ds1"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestProbeHandler_RefusesCredentialsForArbitraryTargets(t *testing.T) {
+	withProbeExporters(t)
+	withModules(t, map[string]*module{
+		defaultModuleName: {Timeout: time.Second, BindDN: "cn=exporter,cn=config", BindPassword: "secret"},
+		"autobind":        {Timeout: time.Second, BindMethod: bindMethodExternal},
+	})
+
+	for _, module := range []string{"", defaultModuleName} {
+		rec := doProbe(url.Values{"target": {"attacker.example.com:389"}, "module": {module}})
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("module %q: status = %d, want 403", module, rec.Code)
+		}
+	}
+	if rec := doProbe(url.Values{"target": {"/nonexistent.socket"}, "module": {"autobind"}}); rec.Code == http.StatusForbidden {
+		t.Error("a module without a bind password should probe any target")
+	}
+}
+
+func TestProbeHandler_SyntheticOnlyForConfiguredTargets(t *testing.T) {
+	withProbeExporters(t)
+	_, path := newUnixLDAPStub(t, []*ldap.Entry{{
+		DN:         "cn=monitor",
+		Attributes: []*ldap.EntryAttribute{{Name: "threads", Values: []string{"8"}}},
+	}})
+	orig := syntheticProbes
+	syntheticProbes = []*syntheticProbe{testSyntheticProbe(t)}
+	t.Cleanup(func() { syntheticProbes = orig })
+	withModules(t, map[string]*module{defaultModuleName: testModule()})
+	withTargets(t, map[string]scrapeTarget{
+		// The stub does not answer every step; fail them quickly.
+		"ds1": {Name: "ds1", URL: "ldapi://" + path, Module: &module{Timeout: 200 * time.Millisecond}},
+	})
+
+	body, _ := io.ReadAll(doProbe(url.Values{"target": {"ds1"}}).Body)
+	if !strings.Contains(string(body), "ds_exporter_synthetic_step_success") {
+		t.Errorf("a configured target should run the synthetic probes:\n%s", body)
+	}
+	body, _ = io.ReadAll(doProbe(url.Values{"target": {path}}).Body)
+	if strings.Contains(string(body), "ds_exporter_synthetic_step_success") {
+		t.Errorf("an arbitrary target must not run the synthetic probes:\n%s", body)
+	}
+}
+
+func TestProbeHandler_PlainURLForTLSModule(t *testing.T) {
+	withModules(t, map[string]*module{
+		defaultModuleName: testModule(),
+		"secure":          {Timeout: time.Second, TLS: tlsSettings{Mode: tlsModeLDAPS}},
+	})
+
+	rec := doProbe(url.Values{"target": {"ldap://ds1:389"}, "module": {"secure"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestProbeHandler_ScrapesTarget(t *testing.T) {
+	withProbeExporters(t)
+	_, path := newUnixLDAPStub(t, []*ldap.Entry{{
+		DN:         "cn=monitor",
+		Attributes: []*ldap.EntryAttribute{{Name: "threads", Values: []string{"24"}}},
+	}})
+	withModules(t, map[string]*module{
+		defaultModuleName: testModule(),
+		"autobind":        {Timeout: 5 * time.Second, BindMethod: bindMethodExternal},
+	})
+
+	rec := doProbe(url.Values{"target": {path}, "module": {"autobind"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "ds_exporter_threads 24") {
+		t.Errorf("probe output missing ds_exporter_threads 24:\n%s", body)
+	}
+}
+
+func TestProbeHandler_ConfiguredTarget(t *testing.T) {
+	withProbeExporters(t)
+	_, path := newUnixLDAPStub(t, []*ldap.Entry{{
+		DN:         "cn=monitor",
+		Attributes: []*ldap.EntryAttribute{{Name: "threads", Values: []string{"8"}}},
+	}})
+	withModules(t, map[string]*module{defaultModuleName: testModule()})
+	withTargets(t, map[string]scrapeTarget{
+		"ds1": {Name: "ds1", URL: "ldapi://" + path, Module: testModule(), Labels: map[string]string{"site": "east"}},
+	})
+
+	rec := doProbe(url.Values{"target": {"ds1"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), `ds_exporter_threads{site="east",target="ds1"} 8`) {
+		t.Errorf("probe output missing labelled threads metric:\n%s", body)
+	}
+}
+
+func TestProbeHandler_KeepsBreakerAcrossProbes(t *testing.T) {
+	withProbeExporters(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	withModules(t, map[string]*module{defaultModuleName: testModule()})
+
+	for i := 0; i < breakerThreshold; i++ {
+		doProbe(url.Values{"target": {addr}})
+	}
+	body, _ := io.ReadAll(doProbe(url.Values{"target": {addr}}).Body)
+	if !strings.Contains(string(body), `ds_exporter_circuit_breaker_state{state="open"} 1`) {
+		t.Errorf("breaker should be open after %d failed probes:\n%s", breakerThreshold, body)
+	}
+
+	probeMu.Lock()
+	n := len(probeExporters)
+	probeMu.Unlock()
+	if n != 1 {
+		t.Errorf("%d cached exporters, want 1", n)
+	}
+}
+
+func TestProbeExporter_ReapsIdle(t *testing.T) {
+	withProbeExporters(t)
+	st := scrapeTarget{URL: "ldap://ds1.example.com:389", Module: testModule()}
+	e, done := probeExporter(st, true)
+	done()
+	again, done := probeExporter(st, true)
+	if again != e {
+		t.Error("a second probe should reuse the exporter")
+	}
+
+	// A probe in flight keeps its exporter however old.
+	reapIdleProbeExporters(time.Now().Add(2 * probeExporterIdle))
+	if len(probeExporters) != 1 {
+		t.Fatal("an exporter in use was reaped")
+	}
+	done()
+	reapIdleProbeExporters(time.Now().Add(2 * probeExporterIdle))
+	if len(probeExporters) != 0 {
+		t.Error("an idle exporter should have been reaped")
+	}
+}
+
+func TestProbeExporter_EvictsLeastRecentlyUsed(t *testing.T) {
+	withProbeExporters(t)
+	mod := testModule()
+	target := func(i int) scrapeTarget {
+		return scrapeTarget{URL: fmt.Sprintf("ldap://ds%d.example.com:389", i), Module: mod}
+	}
+	first, done := probeExporter(target(0), false)
+	done()
+	for i := 1; i < probeExportersMax; i++ {
+		_, done := probeExporter(target(i), false)
+		done()
+	}
+	// Using the first target again makes the second the oldest.
+	_, done = probeExporter(target(0), false)
+	done()
+
+	_, done = probeExporter(target(probeExportersMax), false)
+	done()
+	if len(probeExporters) != probeExportersMax {
+		t.Errorf("%d cached exporters, want %d", len(probeExporters), probeExportersMax)
+	}
+	if again, done := probeExporter(target(0), false); again != first {
+		t.Error("the recently used exporter was evicted")
+	} else {
+		done()
+	}
+	if _, ok := probeExporters[probeKey{url: target(1).URL, mod: mod}]; ok {
+		t.Error("the least recently used exporter was kept")
+	}
+}
+
+func TestProbeExporter_AllBusy(t *testing.T) {
+	withProbeExporters(t)
+	mod := testModule()
+	for i := 0; i < probeExportersMax; i++ {
+		// Left in flight.
+		_, _ = probeExporter(scrapeTarget{URL: fmt.Sprintf("ldap://ds%d.example.com:389", i), Module: mod}, false)
+	}
+	e, done := probeExporter(scrapeTarget{URL: "ldap://extra.example.com:389", Module: mod}, false)
+	defer done()
+	if e == nil || len(probeExporters) != probeExportersMax {
+		t.Errorf("got %d cached exporters, want the extra probe served uncached", len(probeExporters))
+	}
+}
+
+func TestMetricsHandler_LiveCollectors(t *testing.T) {
+	orig := liveCollectors
+	t.Cleanup(func() { liveCollectors = orig })
+
+	e := mockExporter(routeMock(map[string][]*ldap.Entry{
+		"cn=monitor": monitorTestEntries(func(int) string { return "1" }),
+	}))
+	liveCollectors = []liveCollector{{target: scrapeTarget{Name: "ds1"}, c: e}}
+
+	rec := httptest.NewRecorder()
+	metricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), `ds_exporter_up{target="ds1"} 1`) {
+		t.Errorf("/metrics lacks the target's up metric:\n%s", body)
+	}
+}
+
+func TestScrapeContext_PrometheusTimeout(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "2.5")
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("scrape context has no deadline")
+	}
+	if left := time.Until(deadline); left > 2500*time.Millisecond || left < 2*time.Second {
+		t.Errorf("deadline in %v, want about 2.5s", left)
+	}
+
+	ctx, cancel = scrapeContext(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("a request without the header should not get a deadline")
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// defaultQueryPageSize is the paged results size used when a query does not
+// set page_size.
+const defaultQueryPageSize = 500
+
+// Label sources that are not attributes of the entry.
+const (
+	labelSourceDN     = "dn"
+	labelSourceParent = "parent"
+)
+
+var queryScopes = map[string]int{
+	"base": ldap.ScopeBaseObject,
+	"one":  ldap.ScopeSingleLevel,
+	"sub":  ldap.ScopeWholeSubtree,
+}
+
+// queryConfig declares a search whose results become metrics.
+//
+//	queries:
+//	  - name: people
+//	    base: dc=example,dc=com
+//	    filter: (objectClass=person)
+//	    metrics:
+//	      - name: ds_exporter_query_people
+//	        help: Number of person entries per container
+//	        labels:
+//	          container: parent
+type queryConfig struct {
+	Name     string              `yaml:"name"`
+	Base     string              `yaml:"base"`
+	Scope    string              `yaml:"scope"`
+	Filter   string              `yaml:"filter"`
+	Attrs    []string            `yaml:"attrs"`
+	Timeout  time.Duration       `yaml:"timeout"`
+	PageSize uint32              `yaml:"page_size"`
+	Metrics  []queryMetricConfig `yaml:"metrics"`
+}
+
+// queryMetricConfig maps the results of a query to one metric. Without a
+// value attribute the metric counts the matching entries; with one it is the
+// attribute's value. Entries with the same label values are added up.
+// Labels map a label name to an attribute of the entry, or to dn or parent
+// for the entry's DN or its parent's.
+type queryMetricConfig struct {
+	Name   string            `yaml:"name"`
+	Type   string            `yaml:"type"`
+	Help   string            `yaml:"help"`
+	Value  string            `yaml:"value"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+// query is a validated queryConfig.
+type query struct {
+	name     string
+	base     string
+	scope    int
+	filter   string
+	attrs    []string
+	timeout  time.Duration
+	pageSize uint32
+	metrics  []queryMetric
+}
+
+type queryMetric struct {
+	name       string
+	desc       *prometheus.Desc
+	valueType  prometheus.ValueType
+	value      string
+	labelNames []string
+	sources    []string
+}
+
+// queries holds the queries declared in --config.file; every target runs
+// them.
+var queries []*query
+
+// buildQueries validates the query declarations.
+func buildQueries(cfgs []queryConfig) ([]*query, error) {
+	seen := make(map[string]bool)
+	var qs []*query
+	for i, qc := range cfgs {
+		id := fmt.Sprintf("query #%d", i+1)
+		if qc.Name != "" {
+			id = fmt.Sprintf("query %q", qc.Name)
+		}
+		q, err := qc.build(seen)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+		qs = append(qs, q)
+	}
+	if len(qs) == 0 {
+		return nil, nil
+	}
+
+	// A query metric named like one of the exporter's own would fail the
+	// registration of every target.
+	builtin := prometheus.NewPedanticRegistry()
+	builtin.MustRegister(describer(describeBuiltin))
+	for _, q := range qs {
+		for _, m := range q.metrics {
+			if err := builtin.Register(describer(func(ch chan<- *prometheus.Desc) { ch <- m.desc })); err != nil {
+				return nil, fmt.Errorf("query %q: metric %s clashes with a built-in metric: %w", q.name, m.name, err)
+			}
+		}
+	}
+	return qs, nil
+}
+
+func (qc queryConfig) build(seenMetrics map[string]bool) (*query, error) {
+	if qc.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if qc.Base == "" {
+		return nil, fmt.Errorf("base is required")
+	}
+	if _, err := ldap.ParseDN(qc.Base); err != nil {
+		return nil, fmt.Errorf("invalid base %q: %v", qc.Base, err)
+	}
+	q := &query{
+		name:     qc.Name,
+		base:     qc.Base,
+		filter:   qc.Filter,
+		timeout:  qc.Timeout,
+		pageSize: qc.PageSize,
+	}
+	scope := qc.Scope
+	if scope == "" {
+		scope = "sub"
+	}
+	var ok bool
+	if q.scope, ok = queryScopes[scope]; !ok {
+		return nil, fmt.Errorf("invalid scope %q: must be base, one or sub", qc.Scope)
+	}
+	if q.filter == "" {
+		q.filter = "(objectclass=*)"
+	}
+	if _, err := ldap.CompileFilter(q.filter); err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v", q.filter, err)
+	}
+	if q.timeout < 0 {
+		return nil, fmt.Errorf("timeout must not be negative, got %v", q.timeout)
+	}
+	if q.pageSize == 0 {
+		q.pageSize = defaultQueryPageSize
+	}
+	if len(qc.Metrics) == 0 {
+		return nil, fmt.Errorf("at least one metric is required")
+	}
+
+	attrs := make(map[string]bool)
+	for _, a := range qc.Attrs {
+		attrs[strings.ToLower(a)] = true
+	}
+	for _, mc := range qc.Metrics {
+		m, err := mc.build()
+		if err != nil {
+			return nil, err
+		}
+		if seenMetrics[mc.Name] {
+			return nil, fmt.Errorf("metric %s is declared twice", mc.Name)
+		}
+		seenMetrics[mc.Name] = true
+		if m.value != "" {
+			attrs[m.value] = true
+		}
+		for _, s := range m.sources {
+			if s != labelSourceDN && s != labelSourceParent {
+				attrs[s] = true
+			}
+		}
+		q.metrics = append(q.metrics, m)
+	}
+	for a := range attrs {
+		q.attrs = append(q.attrs, a)
+	}
+	sort.Strings(q.attrs)
+	// Counting only needs the DNs.
+	if len(q.attrs) == 0 {
+		q.attrs = []string{"1.1"}
+	}
+	return q, nil
+}
+
+func (mc queryMetricConfig) build() (queryMetric, error) {
+	if !model.IsValidLegacyMetricName(mc.Name) {
+		return queryMetric{}, fmt.Errorf("invalid metric name %q", mc.Name)
+	}
+	m := queryMetric{name: mc.Name, value: strings.ToLower(mc.Value), valueType: prometheus.GaugeValue}
+	switch mc.Type {
+	case "", "gauge":
+	case "counter":
+		m.valueType = prometheus.CounterValue
+	default:
+		return queryMetric{}, fmt.Errorf("metric %s: type must be counter or gauge, got %q", mc.Name, mc.Type)
+	}
+
+	for name := range mc.Labels {
+		if !model.LabelName(name).IsValidLegacy() {
+			return queryMetric{}, fmt.Errorf("metric %s: invalid label name %q", mc.Name, name)
+		}
+		if name == "target" {
+			return queryMetric{}, fmt.Errorf("metric %s: label name %q is reserved", mc.Name, name)
+		}
+		m.labelNames = append(m.labelNames, name)
+	}
+	sort.Strings(m.labelNames)
+	for _, name := range m.labelNames {
+		m.sources = append(m.sources, strings.ToLower(mc.Labels[name]))
+	}
+
+	help := mc.Help
+	if help == "" {
+		help = "Results of an LDAP query declared in the configuration file"
+	}
+	m.desc = prometheus.NewDesc(mc.Name, help, m.labelNames, nil)
+	return m, nil
+}
+
+// labelValues returns the label values of m for entry.
+func (m queryMetric) labelValues(entry *ldap.Entry) []string {
+	values := make([]string, len(m.sources))
+	for i, s := range m.sources {
+		switch s {
+		case labelSourceDN:
+			values[i] = entry.DN
+		case labelSourceParent:
+			if dn, err := ldap.ParseDN(entry.DN); err == nil && len(dn.RDNs) > 1 {
+				values[i] = (&ldap.DN{RDNs: dn.RDNs[1:]}).String()
+			}
+		default:
+			values[i] = entry.GetEqualFoldAttributeValue(s)
+		}
+	}
+	return values
+}
+
+// searchPaged runs req with the paged results control and returns the
+// entries of every page.
+func searchPaged(conn LDAPClient, req *ldap.SearchRequest, pageSize uint32) ([]*ldap.Entry, error) {
+	paging := ldap.NewControlPaging(pageSize)
+	req.Controls = append(req.Controls, paging)
+
+	var entries []*ldap.Entry
+	for {
+		sr, err := conn.Search(req)
+		if err != nil {
+			return nil, fmt.Errorf("LDAP search of %s failed: %w", req.BaseDN, err)
+		}
+		if sr == nil {
+			return nil, fmt.Errorf("LDAP search of %s returned nil result", req.BaseDN)
+		}
+		entries = append(entries, sr.Entries...)
+
+		resp, ok := ldap.FindControl(sr.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+		if !ok || len(resp.Cookie) == 0 {
+			return entries, nil
+		}
+		paging.SetCookie(resp.Cookie)
+	}
+}
+
+// queryCollector runs the configured queries against each target.
+type queryCollector struct {
+	queries []*query
+}
+
+func newQueryCollector(qs []*query) *queryCollector {
+	return &queryCollector{queries: qs}
+}
+
+func (c *queryCollector) describe(ch chan<- *prometheus.Desc) {
+	for _, q := range c.queries {
+		for _, m := range q.metrics {
+			ch <- m.desc
+		}
+	}
+}
+
+func (c *queryCollector) collect(ctx context.Context, conn LDAPClient, timeout time.Duration, _ []*ldap.Entry, ch chan<- prometheus.Metric) error {
+	var firstErr error
+	for _, q := range c.queries {
+		if err := q.run(ctx, conn, timeout, ch); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("query %s: %w", q.name, err)
+		}
+	}
+	return firstErr
+}
+
+// run executes q and emits its metrics. Its own timeout, or else the
+// module's, is sent as the search time limit, in whole seconds rounded up:
+// the server ends a slow query with timeLimitExceeded and the connection
+// stays usable for the other queries and collectors. Only a server that
+// overruns the limit by a further module timeout gets the connection closed.
+func (q *query) run(ctx context.Context, conn LDAPClient, timeout time.Duration, ch chan<- prometheus.Metric) error {
+	limit := timeout
+	if q.timeout > 0 {
+		limit = q.timeout
+	}
+	req := ldap.NewSearchRequest(
+		q.base,
+		q.scope, ldap.NeverDerefAliases, 0, int(math.Ceil(limit.Seconds())), false,
+		q.filter,
+		q.attrs,
+		nil,
+	)
+	entries, err := runLDAP(ctx, conn, limit+timeout, func() ([]*ldap.Entry, error) {
+		return searchPaged(conn, req, q.pageSize)
+	})
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultTimeLimitExceeded) {
+		return fmt.Errorf("exceeded its timeout of %v: %w", limit, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, m := range q.metrics {
+		type sample struct {
+			labels []string
+			value  float64
+		}
+		samples := make(map[string]*sample)
+		var order []string
+		for _, e := range entries {
+			v := 1.0
+			if m.value != "" {
+				f, err := strconv.ParseFloat(e.GetEqualFoldAttributeValue(m.value), 64)
+				if err != nil {
+					continue
+				}
+				v = f
+			}
+			labels := m.labelValues(e)
+			key := strings.Join(labels, "\xff")
+			s, ok := samples[key]
+			if !ok {
+				s = &sample{labels: labels}
+				samples[key] = s
+				order = append(order, key)
+			}
+			s.value += v
+		}
+		// A count without labels is reported even when nothing matched.
+		if m.value == "" && len(m.labelNames) == 0 && len(samples) == 0 {
+			ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, 0)
+			continue
+		}
+		for _, key := range order {
+			s := samples[key]
+			ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, s.value, s.labels...)
+		}
+	}
+	return nil
+}
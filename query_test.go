@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pagedMock serves pages of entries, one per search, linked by the paged
+// results cookie.
+func pagedMock(t *testing.T, pages ...[]*ldap.Entry) (*mockLDAP, *int) {
+	searches := 0
+	return &mockLDAP{
+		searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			paging, ok := ldap.FindControl(req.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+			if !ok {
+				t.Error("search sent without the paged results control")
+				return nil, errors.New("no paging")
+			}
+			page := 0
+			if len(paging.Cookie) > 0 {
+				page = int(paging.Cookie[0])
+			}
+			searches++
+			sr := &ldap.SearchResult{Entries: pages[page]}
+			if page+1 < len(pages) {
+				next := ldap.NewControlPaging(paging.PagingSize)
+				next.SetCookie([]byte{byte(page + 1)})
+				sr.Controls = []ldap.Control{next}
+			}
+			return sr, nil
+		},
+		closeFunc: func() error { return nil },
+	}, &searches
+}
+
+func person(dn, size string) *ldap.Entry {
+	return &ldap.Entry{DN: dn, Attributes: []*ldap.EntryAttribute{{Name: "mailQuota", Values: []string{size}}}}
+}
+
+func TestQueryCollector(t *testing.T) {
+	qs, err := buildQueries([]queryConfig{{
+		Name:     "people",
+		Base:     "dc=example,dc=com",
+		Filter:   "(objectClass=person)",
+		PageSize: 2,
+		Metrics: []queryMetricConfig{
+			{Name: "ds_exporter_query_people", Labels: map[string]string{"ou": "parent"}},
+			{Name: "ds_exporter_query_mail_quota_bytes", Value: "mailquota"},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock, searches := pagedMock(t,
+		[]*ldap.Entry{
+			person("uid=a,ou=People,dc=example,dc=com", "10"),
+			person("uid=b,ou=People,dc=example,dc=com", "20"),
+		},
+		[]*ldap.Entry{
+			person("uid=c,ou=Staff,dc=example,dc=com", "not-a-number"),
+		},
+	)
+	c := collectorFunc{c: newQueryCollector(qs), conn: mock}
+
+	if v, _ := gatherValue(t, c, "ds_exporter_query_people", map[string]string{"ou": "ou=People,dc=example,dc=com"}); v != 2 {
+		t.Errorf("people in ou=People = %v, want 2", v)
+	}
+	if v, _ := gatherValue(t, c, "ds_exporter_query_people", map[string]string{"ou": "ou=Staff,dc=example,dc=com"}); v != 1 {
+		t.Errorf("people in ou=Staff = %v, want 1", v)
+	}
+	if v, _ := gatherValue(t, c, "ds_exporter_query_mail_quota_bytes", nil); v != 30 {
+		t.Errorf("mail quota = %v, want 30", v)
+	}
+	// Three gathers of two pages each
+	if *searches != 6 {
+		t.Errorf("searches = %d, want 6", *searches)
+	}
+}
+
+func TestQueryCollector_EmptyCount(t *testing.T) {
+	qs, err := buildQueries([]queryConfig{{
+		Name:    "locked",
+		Base:    "dc=example,dc=com",
+		Filter:  "(nsAccountLock=true)",
+		Metrics: []queryMetricConfig{{Name: "ds_exporter_query_locked_accounts"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock, _ := pagedMock(t, nil)
+	c := collectorFunc{c: newQueryCollector(qs), conn: mock}
+
+	if v, ok := gatherValue(t, c, "ds_exporter_query_locked_accounts", nil); !ok || v != 0 {
+		t.Errorf("locked accounts = %v (present %v), want 0", v, ok)
+	}
+	if got := qs[0].attrs; len(got) != 1 || got[0] != "1.1" {
+		t.Errorf("attrs = %v, want [1.1] for a count", got)
+	}
+}
+
+func TestQueryRun_TimeLimit(t *testing.T) {
+	qs, err := buildQueries([]queryConfig{
+		{Name: "slow", Base: "ou=slow,dc=example,dc=com", Timeout: 1500 * time.Millisecond, Metrics: []queryMetricConfig{{Name: "ds_exporter_query_slow"}}},
+		{Name: "default", Base: "dc=example,dc=com", Metrics: []queryMetricConfig{{Name: "ds_exporter_query_default"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := make(map[string]int)
+	mock := &mockLDAP{
+		searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			limits[req.BaseDN] = req.TimeLimit
+			return &ldap.SearchResult{}, nil
+		},
+		closeFunc: func() error { return nil },
+	}
+
+	if err := newQueryCollector(qs).collect(context.Background(), mock, 5*time.Second, nil, make(chan prometheus.Metric, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if limits["ou=slow,dc=example,dc=com"] != 2 || limits["dc=example,dc=com"] != 5 {
+		t.Errorf("time limits = %v, want 2s for the query timeout and 5s for the module's", limits)
+	}
+}
+
+// A query the server ends for its time limit fails alone: the connection is
+// kept, the next query and collectors still report, and the target stays up
+// without the breaker counting a failure.
+func TestExporter_SlowQuery(t *testing.T) {
+	qs, err := buildQueries([]queryConfig{
+		{Name: "slow", Base: "ou=slow,dc=example,dc=com", Timeout: time.Second, Metrics: []queryMetricConfig{{Name: "ds_exporter_query_slow"}}},
+		{Name: "people", Base: "ou=people,dc=example,dc=com", Metrics: []queryMetricConfig{{Name: "ds_exporter_query_people"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closes, slow := 0, 0
+	mock := &mockLDAP{
+		searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			switch req.BaseDN {
+			case "ou=slow,dc=example,dc=com":
+				// The server answers just after the time limit is up.
+				if slow++; slow == 1 {
+					time.Sleep(1100 * time.Millisecond)
+				}
+				return nil, ldap.NewError(ldap.LDAPResultTimeLimitExceeded, errors.New("time limit exceeded"))
+			case "ou=people,dc=example,dc=com":
+				return &ldap.SearchResult{Entries: []*ldap.Entry{{DN: "uid=a,ou=people,dc=example,dc=com"}}}, nil
+			case pluginsDN:
+				return &ldap.SearchResult{Entries: []*ldap.Entry{pluginEntry("MemberOf Plugin", "on", "betxnpostoperation", "2.4.5")}}, nil
+			}
+			return &ldap.SearchResult{}, nil
+		},
+		closeFunc: func() error {
+			closes++
+			return nil
+		},
+	}
+	e := mockExporter(mock)
+	e.collectors = []collector{newQueryCollector(qs), newPluginCollector()}
+
+	for i := 0; i < breakerThreshold; i++ {
+		if v, _ := gatherValue(t, e, "ds_exporter_query_people", nil); v != 1 {
+			t.Errorf("people = %v, want 1 after the slow query", v)
+		}
+	}
+	if _, ok := gatherValue(t, e, "ds_exporter_plugin_enabled", map[string]string{"plugin": "MemberOf Plugin"}); !ok {
+		t.Error("the collector after the queries did not report")
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_up", nil); v != 1 {
+		t.Errorf("up = %v, want 1", v)
+	}
+	if v, _ := gatherValue(t, e, "ds_exporter_circuit_breaker_state", map[string]string{"state": "closed"}); v != 1 {
+		t.Error("a slow query must not open the circuit breaker")
+	}
+	if closes != 0 {
+		t.Errorf("connection closed %d times, want 0", closes)
+	}
+}
+
+func TestBuildQueries_Errors(t *testing.T) {
+	metric := []queryMetricConfig{{Name: "ds_exporter_query_x"}}
+	tests := []struct {
+		name string
+		cfg  queryConfig
+		want string
+	}{
+		{"no name", queryConfig{Base: "dc=example", Metrics: metric}, "query #1: name is required"},
+		{"no base", queryConfig{Name: "q", Metrics: metric}, "base is required"},
+		{"bad scope", queryConfig{Name: "q", Base: "dc=example", Scope: "children", Metrics: metric}, "invalid scope"},
+		{"bad filter", queryConfig{Name: "q", Base: "dc=example", Filter: "objectClass=*", Metrics: metric}, "invalid filter"},
+		{"no metrics", queryConfig{Name: "q", Base: "dc=example"}, "at least one metric"},
+		{"bad metric name", queryConfig{Name: "q", Base: "dc=example", Metrics: []queryMetricConfig{{Name: "bad-name"}}}, "invalid metric name"},
+		{"bad type", queryConfig{Name: "q", Base: "dc=example", Metrics: []queryMetricConfig{{Name: "m", Type: "histogram"}}}, "type must be"},
+		{"reserved label", queryConfig{Name: "q", Base: "dc=example", Metrics: []queryMetricConfig{{Name: "m", Labels: map[string]string{"target": "cn"}}}}, "reserved"},
+		{"duplicate metric", queryConfig{Name: "q", Base: "dc=example", Metrics: append(metric, metric...)}, "declared twice"},
+		{"built-in up", queryConfig{Name: "q", Base: "dc=example", Metrics: []queryMetricConfig{{Name: "ds_exporter_up"}}}, "metric ds_exporter_up clashes with a built-in metric"},
+		{"built-in threads", queryConfig{Name: "q", Base: "dc=example", Metrics: []queryMetricConfig{{Name: "ds_exporter_threads"}}}, "clashes with a built-in metric"},
+		{"built-in snapshot", queryConfig{Name: "q", Base: "dc=example", Metrics: []queryMetricConfig{{Name: "ds_exporter_snapshot_stale"}}}, "clashes with a built-in metric"},
+		{"built-in errors log", queryConfig{Name: "q", Base: "dc=example", Metrics: []queryMetricConfig{{Name: "ds_exporter_errors_log_lines_total"}}}, "clashes with a built-in metric"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildQueries([]queryConfig{tt.cfg})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryLabelClashesWithTargetLabel(t *testing.T) {
+	qs, err := buildQueries([]queryConfig{{
+		Name:    "people",
+		Base:    "dc=example,dc=com",
+		Metrics: []queryMetricConfig{{Name: "ds_exporter_query_people", Labels: map[string]string{"site": "l"}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := queries
+	queries = qs
+	t.Cleanup(func() { queries = orig })
+
+	tgt := scrapeTarget{Name: "ds1", URL: "ldap://ds1:389", Module: testModule(), Labels: map[string]string{"site": "east"}}
+	if err := tgt.checkLabels(); err == nil || !strings.Contains(err.Error(), "ds_exporter_query_people") {
+		t.Errorf("err = %v, want a clash with ds_exporter_query_people", err)
+	}
+}
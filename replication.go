@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// mappingTreeDN holds the replica configuration; agreements are entries of
+// class nsds5replicationagreement below each suffix's cn=replica.
+const mappingTreeDN = "cn=mapping tree,cn=config"
+
+const agreementFilter = "(objectclass=nsds5replicationagreement)"
+
+// generalizedTimeLayout is the GeneralizedTime form 389-DS uses for the
+// agreement timestamps, e.g. 20240131120000Z.
+const generalizedTimeLayout = "20060102150405Z"
+
+var agreementAttrs = []string{
+	"cn",
+	"nsds5replicaroot",
+	"nsds5replicahost",
+	"nsds5replicaport",
+	"nsds5replicalastupdatestart",
+	"nsds5replicalastupdateend",
+	"nsds5replicalastupdatestatus",
+	"nsds5replicaupdateinprogress",
+	"nsds5replicachangessentsincestartup",
+	"nsds5replicalastinitstatus",
+}
+
+// statusCodeRe matches the numeric code that starts a replication status,
+// in both the "Error (0) Replica acquired successfully" form and the older
+// "0 Replica acquired successfully" one.
+var statusCodeRe = regexp.MustCompile(`^\s*(?:Error\s*\(\s*(-?\d+)\s*\)|(-?\d+)\s)`)
+
+// replicationCollector exports the status of every replication agreement
+// defined on the server.
+type replicationCollector struct {
+	lastUpdateStart  *prometheus.Desc
+	lastUpdateEnd    *prometheus.Desc
+	lastUpdateStatus *prometheus.Desc
+	updateInProgress *prometheus.Desc
+	changesSent      *prometheus.Desc
+	changesSkipped   *prometheus.Desc
+	lastInitStatus   *prometheus.Desc
+}
+
+func newReplicationCollector() *replicationCollector {
+	labels := []string{"suffix", "agreement", "consumer"}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+	}
+	return &replicationCollector{
+		lastUpdateStart:  desc("replication_agreement_last_update_start_seconds", "Unix time the last incremental update of the agreement started (0 if never)"),
+		lastUpdateEnd:    desc("replication_agreement_last_update_end_seconds", "Unix time the last incremental update of the agreement ended (0 if never)"),
+		lastUpdateStatus: desc("replication_agreement_last_update_status", "Status code of the last incremental update (0 is success)"),
+		updateInProgress: desc("replication_agreement_update_in_progress", "Whether an update of the agreement is in progress (1) or not (0)"),
+		changesSent:      desc("replication_agreement_changes_sent", "Number of changes sent over the agreement since server startup"),
+		changesSkipped:   desc("replication_agreement_changes_skipped", "Number of changes skipped by the agreement since server startup"),
+		lastInitStatus:   desc("replication_agreement_last_init_status", "Status code of the last total update (0 is success)"),
+	}
+}
+
+func (c *replicationCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lastUpdateStart
+	ch <- c.lastUpdateEnd
+	ch <- c.lastUpdateStatus
+	ch <- c.updateInProgress
+	ch <- c.changesSent
+	ch <- c.changesSkipped
+	ch <- c.lastInitStatus
+}
+
+func (c *replicationCollector) collect(ctx context.Context, conn LDAPClient, timeout time.Duration, _ []*ldap.Entry, ch chan<- prometheus.Metric) error {
+	entries, err := searchFilter(ctx, conn, mappingTreeDN, agreementFilter, agreementAttrs, timeout)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		labels := []string{
+			e.GetEqualFoldAttributeValue("nsds5replicaroot"),
+			e.GetEqualFoldAttributeValue("cn"),
+			net.JoinHostPort(e.GetEqualFoldAttributeValue("nsds5replicahost"), e.GetEqualFoldAttributeValue("nsds5replicaport")),
+		}
+
+		if t, ok := parseGeneralizedTime(e.GetEqualFoldAttributeValue("nsds5replicalastupdatestart")); ok {
+			ch <- prometheus.MustNewConstMetric(c.lastUpdateStart, prometheus.GaugeValue, t, labels...)
+		}
+		if t, ok := parseGeneralizedTime(e.GetEqualFoldAttributeValue("nsds5replicalastupdateend")); ok {
+			ch <- prometheus.MustNewConstMetric(c.lastUpdateEnd, prometheus.GaugeValue, t, labels...)
+		}
+		if code, ok := parseStatusCode(e.GetEqualFoldAttributeValue("nsds5replicalastupdatestatus")); ok {
+			ch <- prometheus.MustNewConstMetric(c.lastUpdateStatus, prometheus.GaugeValue, code, labels...)
+		}
+		if v := e.GetEqualFoldAttributeValue("nsds5replicaupdateinprogress"); v != "" {
+			inProgress := 0.0
+			if strings.EqualFold(v, "true") {
+				inProgress = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.updateInProgress, prometheus.GaugeValue, inProgress, labels...)
+		}
+		if v := e.GetEqualFoldAttributeValues("nsds5replicachangessentsincestartup"); len(v) > 0 {
+			sent, skipped := parseChangesSent(v)
+			ch <- prometheus.MustNewConstMetric(c.changesSent, prometheus.CounterValue, sent, labels...)
+			ch <- prometheus.MustNewConstMetric(c.changesSkipped, prometheus.CounterValue, skipped, labels...)
+		}
+		if code, ok := parseStatusCode(e.GetEqualFoldAttributeValue("nsds5replicalastinitstatus")); ok {
+			ch <- prometheus.MustNewConstMetric(c.lastInitStatus, prometheus.GaugeValue, code, labels...)
+		}
+	}
+	return nil
+}
+
+// parseGeneralizedTime converts an agreement timestamp to Unix seconds. The
+// server reports 19700101000000Z for updates that never happened, which
+// yields 0.
+func parseGeneralizedTime(v string) (float64, bool) {
+	if v == "" {
+		return 0, false
+	}
+	t, err := time.Parse(generalizedTimeLayout, v)
+	if err != nil {
+		return 0, false
+	}
+	return float64(t.Unix()), true
+}
+
+// parseStatusCode extracts the leading numeric code of a replication status
+// such as "Error (0) Replica acquired successfully: Incremental update succeeded".
+func parseStatusCode(v string) (float64, bool) {
+	m := statusCodeRe.FindStringSubmatch(v)
+	if m == nil {
+		return 0, false
+	}
+	code := m[1]
+	if code == "" {
+		code = m[2]
+	}
+	f, err := strconv.ParseFloat(code, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// parseChangesSent sums the per-replica "rid:sent/skipped" counts of
+// nsds5replicaChangesSentSinceStartup, e.g. "1:120/3 2:5/0".
+func parseChangesSent(values []string) (sent, skipped float64) {
+	for _, value := range values {
+		for _, field := range strings.Fields(value) {
+			_, counts, ok := strings.Cut(field, ":")
+			if !ok {
+				continue
+			}
+			s, k, _ := strings.Cut(counts, "/")
+			if n, err := strconv.ParseFloat(s, 64); err == nil {
+				sent += n
+			}
+			if n, err := strconv.ParseFloat(k, 64); err == nil {
+				skipped += n
+			}
+		}
+	}
+	return sent, skipped
+}
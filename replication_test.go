@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const agreementDN = "cn=to-consumer1,cn=replica,cn=dc\\3Dexample\\2Cdc\\3Dcom,cn=mapping tree,cn=config"
+
+func TestReplicationCollector(t *testing.T) {
+	e := mockExporter(routeMock(map[string][]*ldap.Entry{
+		mappingTreeDN: {{
+			DN: agreementDN,
+			Attributes: []*ldap.EntryAttribute{
+				{Name: "cn", Values: []string{"to-consumer1"}},
+				{Name: "nsDS5ReplicaRoot", Values: []string{"dc=example,dc=com"}},
+				{Name: "nsDS5ReplicaHost", Values: []string{"consumer1.example.com"}},
+				{Name: "nsDS5ReplicaPort", Values: []string{"389"}},
+				{Name: "nsds5replicaLastUpdateStart", Values: []string{"20240131120000Z"}},
+				{Name: "nsds5replicaLastUpdateEnd", Values: []string{"20240131120005Z"}},
+				{Name: "nsds5replicaLastUpdateStatus", Values: []string{"Error (-1) Can't acquire busy replica"}},
+				{Name: "nsds5replicaUpdateInProgress", Values: []string{"TRUE"}},
+				{Name: "nsds5replicaChangesSentSinceStartup", Values: []string{"1:120/3 2:5/0"}},
+				{Name: "nsds5replicaLastInitStatus", Values: []string{"0 Total update succeeded"}},
+			},
+		}},
+	}))
+
+	labels := map[string]string{
+		"suffix":    "dc=example,dc=com",
+		"agreement": "to-consumer1",
+		"consumer":  "consumer1.example.com:389",
+	}
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{"ds_exporter_replication_agreement_last_update_start_seconds", 1706702400},
+		{"ds_exporter_replication_agreement_last_update_end_seconds", 1706702405},
+		{"ds_exporter_replication_agreement_last_update_status", -1},
+		{"ds_exporter_replication_agreement_update_in_progress", 1},
+		{"ds_exporter_replication_agreement_changes_sent", 125},
+		{"ds_exporter_replication_agreement_changes_skipped", 3},
+		{"ds_exporter_replication_agreement_last_init_status", 0},
+	}
+	for _, tt := range tests {
+		got, ok := gatherValue(t, e, tt.name, labels)
+		if !ok {
+			t.Errorf("%s not exported", tt.name)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatusCode(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   float64
+		wantOK bool
+	}{
+		{"Error (0) Replica acquired successfully: Incremental update succeeded", 0, true},
+		{"Error (18) Replication error acquiring replica: Incremental update transient error", 18, true},
+		{"0 Replica acquired successfully: Incremental update succeeded", 0, true},
+		{"-1 Incremental update has failed", -1, true},
+		{"", 0, false},
+		{"Replica acquired successfully", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseStatusCode(tt.in)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("parseStatusCode(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestParseGeneralizedTime_Never(t *testing.T) {
+	got, ok := parseGeneralizedTime("19700101000000Z")
+	if !ok || got != 0 {
+		t.Errorf("parseGeneralizedTime(epoch) = %v, %v; want 0, true", got, ok)
+	}
+	if _, ok := parseGeneralizedTime("not a time"); ok {
+		t.Error("expected invalid timestamp to be rejected")
+	}
+}
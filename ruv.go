@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	replicaFilter = "(objectclass=nsds5replica)"
+	// tombstoneRUVFilter selects the replica tombstone entry of a suffix,
+	// which holds the database RUV in nsds50ruv.
+	tombstoneRUVFilter = "(&(nsuniqueid=ffffffff-ffffffff-ffffffff-ffffffff)(objectclass=nstombstone))"
+)
+
+// csn is a decoded change sequence number. Its text form is 20 hex digits:
+// 8 for the timestamp, 4 for the sequence number, 4 for the replica ID and 4
+// for the subsequence number.
+type csn struct {
+	Time      time.Time
+	Seq       uint16
+	ReplicaID uint16
+	SubSeq    uint16
+}
+
+func parseCSN(s string) (csn, error) {
+	if len(s) != 20 {
+		return csn{}, fmt.Errorf("invalid CSN %q", s)
+	}
+	ts, err := strconv.ParseUint(s[0:8], 16, 32)
+	if err != nil {
+		return csn{}, fmt.Errorf("invalid CSN %q: %v", s, err)
+	}
+	var parts [3]uint16
+	for i := range parts {
+		v, err := strconv.ParseUint(s[8+4*i:12+4*i], 16, 16)
+		if err != nil {
+			return csn{}, fmt.Errorf("invalid CSN %q: %v", s, err)
+		}
+		parts[i] = uint16(v)
+	}
+	return csn{Time: time.Unix(int64(ts), 0), Seq: parts[0], ReplicaID: parts[1], SubSeq: parts[2]}, nil
+}
+
+// ruvElement is one replica of a RUV: the highest change of that replica
+// ID the server has seen, and the URL of the supplier it came from.
+type ruvElement struct {
+	ReplicaID uint16
+	URL       string
+	MaxCSN    csn
+}
+
+// parseRUVElement decodes an nsds50ruv value such as
+// "{replica 1 ldap://supplier1:389} 5f1a2b3c000000010000 5f1a2b4d000100010000".
+// The replicageneration value and replicas without changes yet are
+// reported as not ok.
+func parseRUVElement(v string) (ruvElement, bool) {
+	head, rest, found := strings.Cut(v, "}")
+	if !found || !strings.HasPrefix(head, "{replica ") {
+		return ruvElement{}, false
+	}
+	fields := strings.Fields(strings.TrimPrefix(head, "{replica "))
+	if len(fields) == 0 {
+		return ruvElement{}, false
+	}
+	rid, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return ruvElement{}, false
+	}
+	csns := strings.Fields(rest)
+	if len(csns) < 2 {
+		return ruvElement{}, false
+	}
+	newest, err := parseCSN(csns[1])
+	if err != nil {
+		return ruvElement{}, false
+	}
+	el := ruvElement{ReplicaID: uint16(rid), MaxCSN: newest}
+	if len(fields) > 1 {
+		el.URL = fields[1]
+	}
+	return el, true
+}
+
+// replicaRUV is the database RUV of one replicated suffix.
+type replicaRUV struct {
+	Suffix string
+	// ReplicaID is the server's own replica ID for the suffix; read-only
+	// consumers use 65535.
+	ReplicaID uint16
+	Elements  []ruvElement
+}
+
+// maxCSN returns the highest change of rid the replica has seen.
+func (r replicaRUV) maxCSN(rid uint16) (time.Time, bool) {
+	for _, el := range r.Elements {
+		if el.ReplicaID == rid {
+			return el.MaxCSN.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// readRUVs reads the replica configuration of every replicated suffix and
+// the RUV of its tombstone entry.
+func readRUVs(ctx context.Context, conn LDAPClient, timeout time.Duration) ([]replicaRUV, error) {
+	replicas, err := searchFilter(ctx, conn, mappingTreeDN, replicaFilter, []string{"nsds5replicaroot", "nsds5replicaid"}, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var ruvs []replicaRUV
+	var firstErr error
+	for _, r := range replicas {
+		suffix := r.GetEqualFoldAttributeValue("nsds5replicaroot")
+		if suffix == "" {
+			continue
+		}
+		ruv := replicaRUV{Suffix: suffix}
+		if rid, err := strconv.ParseUint(r.GetEqualFoldAttributeValue("nsds5replicaid"), 10, 16); err == nil {
+			ruv.ReplicaID = uint16(rid)
+		}
+
+		tombstones, err := searchFilter(ctx, conn, suffix, tombstoneRUVFilter, []string{"nsds50ruv"}, timeout)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, t := range tombstones {
+			for _, v := range t.GetEqualFoldAttributeValues("nsds50ruv") {
+				if el, ok := parseRUVElement(v); ok {
+					ruv.Elements = append(ruv.Elements, el)
+				}
+			}
+		}
+		ruvs = append(ruvs, ruv)
+	}
+	return ruvs, firstErr
+}
+
+// ruvCollector exports the RUV of every replicated suffix of one server.
+type ruvCollector struct {
+	maxCSNDesc    *prometheus.Desc
+	replicaIDDesc *prometheus.Desc
+
+	mu    sync.Mutex
+	last  []replicaRUV
+	taken time.Time
+}
+
+func newRUVCollector() *ruvCollector {
+	return &ruvCollector{
+		maxCSNDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "replication_ruv_max_csn_seconds"),
+			"Unix time of the newest change of each replica ID in the database RUV",
+			[]string{"suffix", "replica_id", "replica_url"}, nil,
+		),
+		replicaIDDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "replication_replica_id"),
+			"Replica ID of the server for the suffix (65535 for read-only consumers)",
+			[]string{"suffix"}, nil,
+		),
+	}
+}
+
+func (c *ruvCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxCSNDesc
+	ch <- c.replicaIDDesc
+}
+
+func (c *ruvCollector) collect(ctx context.Context, conn LDAPClient, timeout time.Duration, _ []*ldap.Entry, ch chan<- prometheus.Metric) error {
+	ruvs, err := readRUVs(ctx, conn, timeout)
+	c.mu.Lock()
+	c.last, c.taken = ruvs, time.Now()
+	c.mu.Unlock()
+	for _, r := range ruvs {
+		ch <- prometheus.MustNewConstMetric(c.replicaIDDesc, prometheus.GaugeValue, float64(r.ReplicaID), r.Suffix)
+		for _, el := range r.Elements {
+			ch <- prometheus.MustNewConstMetric(c.maxCSNDesc, prometheus.GaugeValue,
+				float64(el.MaxCSN.Time.Unix()), r.Suffix, strconv.Itoa(int(el.ReplicaID)), el.URL)
+		}
+	}
+	return err
+}
+
+// lastRUVs returns the RUVs read by the last collect, or nil if they are
+// older than maxAge.
+func (c *ruvCollector) lastRUVs(maxAge time.Duration) []replicaRUV {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.taken) > maxAge {
+		return nil
+	}
+	return c.last
+}
+
+// lagTarget is one named server taking part in the lag computation.
+type lagTarget struct {
+	name     string
+	exporter *Exporter
+}
+
+// replicationLagCollector compares the RUVs of all configured targets. The
+// supplier of a replica ID is the target whose own replica ID it is; every
+// other target replicating the suffix is a consumer, and its lag is how far
+// its newest change of that replica ID trails the supplier's.
+//
+// With a snapshotAge the RUVs are not searched but taken from the last poll
+// of every target, skipping those older than snapshotAge; the lag is then
+// only as precise as the poll interval.
+type replicationLagCollector struct {
+	targets     []lagTarget
+	snapshotAge time.Duration
+	lagDesc     *prometheus.Desc
+}
+
+func newReplicationLagCollector(targets []lagTarget, snapshotAge time.Duration) *replicationLagCollector {
+	return &replicationLagCollector{
+		targets:     targets,
+		snapshotAge: snapshotAge,
+		lagDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "replication_lag_seconds"),
+			"How far the consumer's newest change of the replica ID trails the supplier's, from the database RUVs",
+			[]string{"suffix", "replica_id", "supplier", "consumer"}, nil,
+		),
+	}
+}
+
+func (c *replicationLagCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lagDesc
+}
+
+func (c *replicationLagCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+// CollectContext is Collect with the RUV searches bounded by ctx.
+func (c *replicationLagCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	var ruvs [][]replicaRUV
+	if c.snapshotAge > 0 {
+		ruvs = c.snapshotRUVs()
+	} else {
+		ruvs = c.readRUVs(ctx)
+	}
+
+	for si, supplier := range c.targets {
+		for _, sr := range ruvs[si] {
+			supplied, ok := sr.maxCSN(sr.ReplicaID)
+			if !ok {
+				continue
+			}
+			for ci, consumer := range c.targets {
+				if ci == si {
+					continue
+				}
+				for _, cr := range ruvs[ci] {
+					if !strings.EqualFold(cr.Suffix, sr.Suffix) {
+						continue
+					}
+					seen, ok := cr.maxCSN(sr.ReplicaID)
+					if !ok {
+						continue
+					}
+					ch <- prometheus.MustNewConstMetric(c.lagDesc, prometheus.GaugeValue,
+						max(supplied.Sub(seen).Seconds(), 0),
+						sr.Suffix, strconv.Itoa(int(sr.ReplicaID)), supplier.name, consumer.name)
+				}
+			}
+		}
+	}
+}
+
+// readRUVs searches the RUVs of every target.
+func (c *replicationLagCollector) readRUVs(ctx context.Context) [][]replicaRUV {
+	ruvs := make([][]replicaRUV, len(c.targets))
+	var wg sync.WaitGroup
+	for i, t := range c.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := t.exporter.getLDAPConn(ctx)
+			if err != nil {
+				log.Printf("Error reading RUV of %s: %v", t.name, err)
+				return
+			}
+			r, err := readRUVs(ctx, conn, t.exporter.mod.Timeout)
+			var connErr error
+			if err != nil {
+				log.Printf("Error reading RUV of %s: %v", t.name, err)
+				if isCancelled(err) {
+					connErr = err
+				}
+			}
+			t.exporter.releaseLDAPConn(conn, connErr)
+			ruvs[i] = r
+		}()
+	}
+	wg.Wait()
+	return ruvs
+}
+
+// snapshotRUVs returns the RUVs of every target's last poll.
+func (c *replicationLagCollector) snapshotRUVs() [][]replicaRUV {
+	ruvs := make([][]replicaRUV, len(c.targets))
+	for i, t := range c.targets {
+		ruvs[i] = t.exporter.ruvs.lastRUVs(c.snapshotAge)
+	}
+	return ruvs
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ruvMock serves one replicated suffix with replica ID rid and the given
+// nsds50ruv values on its tombstone entry.
+func ruvMock(rid string, ruv ...string) *mockLDAP {
+	return &mockLDAP{
+		searchFunc: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			switch {
+			case req.BaseDN == mappingTreeDN && req.Filter == replicaFilter:
+				return &ldap.SearchResult{Entries: []*ldap.Entry{{
+					DN: "cn=replica,cn=dc\\3Dexample\\2Cdc\\3Dcom,cn=mapping tree,cn=config",
+					Attributes: []*ldap.EntryAttribute{
+						{Name: "nsDS5ReplicaRoot", Values: []string{"dc=example,dc=com"}},
+						{Name: "nsDS5ReplicaId", Values: []string{rid}},
+					},
+				}}}, nil
+			case req.BaseDN == "dc=example,dc=com" && req.Filter == tombstoneRUVFilter:
+				return &ldap.SearchResult{Entries: []*ldap.Entry{{
+					DN:         "nsuniqueid=ffffffff-ffffffff-ffffffff-ffffffff,dc=example,dc=com",
+					Attributes: []*ldap.EntryAttribute{{Name: "nsds50ruv", Values: ruv}},
+				}}}, nil
+			}
+			return &ldap.SearchResult{}, nil
+		},
+		closeFunc: func() error { return nil },
+	}
+}
+
+func TestParseCSN(t *testing.T) {
+	c, err := parseCSN("65ba3640000a00020003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Time.Equal(time.Unix(0x65ba3640, 0)) || c.Seq != 10 || c.ReplicaID != 2 || c.SubSeq != 3 {
+		t.Errorf("parseCSN = %+v", c)
+	}
+	for _, bad := range []string{"", "65ba3640", "zzba3640000a00020003"} {
+		if _, err := parseCSN(bad); err == nil {
+			t.Errorf("parseCSN(%q) should fail", bad)
+		}
+	}
+}
+
+func TestParseRUVElement(t *testing.T) {
+	el, ok := parseRUVElement("{replica 2 ldap://supplier2.example.com:389} 65ba3000000000020000 65ba3640000a00020000")
+	if !ok {
+		t.Fatal("expected element to parse")
+	}
+	if el.ReplicaID != 2 || el.URL != "ldap://supplier2.example.com:389" || el.MaxCSN.Time.Unix() != 0x65ba3640 {
+		t.Errorf("parseRUVElement = %+v", el)
+	}
+
+	for _, v := range []string{
+		"{replicageneration} 65ba2000000000010000",
+		"{replica 3 ldap://supplier3.example.com:389}",
+	} {
+		if _, ok := parseRUVElement(v); ok {
+			t.Errorf("parseRUVElement(%q) should not be ok", v)
+		}
+	}
+}
+
+func TestRUVCollector(t *testing.T) {
+	e := mockExporter(ruvMock("1",
+		"{replicageneration} 65ba2000000000010000",
+		"{replica 1 ldap://supplier1.example.com:389} 65ba3000000000010000 65ba3640000000010000",
+	))
+
+	got, ok := gatherValue(t, e, "ds_exporter_replication_ruv_max_csn_seconds", map[string]string{
+		"suffix":      "dc=example,dc=com",
+		"replica_id":  "1",
+		"replica_url": "ldap://supplier1.example.com:389",
+	})
+	if !ok || got != 0x65ba3640 {
+		t.Errorf("max CSN time = %v (present %v), want %v", got, ok, 0x65ba3640)
+	}
+	if got, _ := gatherValue(t, e, "ds_exporter_replication_replica_id", map[string]string{"suffix": "dc=example,dc=com"}); got != 1 {
+		t.Errorf("replica id = %v, want 1", got)
+	}
+}
+
+func TestReplicationLagCollector(t *testing.T) {
+	supplier := mockExporter(ruvMock("1",
+		"{replica 1 ldap://supplier1.example.com:389} 65ba3000000000010000 65ba3640000000010000",
+	))
+	consumer := mockExporter(ruvMock("65535",
+		"{replica 1 ldap://supplier1.example.com:389} 65ba3000000000010000 65ba3600000000010000",
+	))
+	c := newReplicationLagCollector([]lagTarget{
+		{name: "supplier1", exporter: supplier},
+		{name: "consumer1", exporter: consumer},
+	}, 0)
+
+	got, ok := gatherValue(t, c, "ds_exporter_replication_lag_seconds", map[string]string{
+		"suffix":     "dc=example,dc=com",
+		"replica_id": "1",
+		"supplier":   "supplier1",
+		"consumer":   "consumer1",
+	})
+	if !ok || got != 0x40 {
+		t.Errorf("lag = %v (present %v), want 64", got, ok)
+	}
+	if _, ok := gatherValue(t, c, "ds_exporter_replication_lag_seconds", map[string]string{"supplier": "consumer1"}); ok {
+		t.Error("a read-only consumer should not be reported as a supplier")
+	}
+}
+
+func TestReplicationLagCollector_FromSnapshots(t *testing.T) {
+	supplierConn := ruvMock("1",
+		"{replica 1 ldap://supplier1.example.com:389} 65ba3000000000010000 65ba3640000000010000",
+	)
+	consumerConn := ruvMock("65535",
+		"{replica 1 ldap://supplier1.example.com:389} 65ba3000000000010000 65ba3600000000010000",
+	)
+	supplier, consumer := mockExporter(supplierConn), mockExporter(consumerConn)
+	for _, e := range []*Exporter{supplier, consumer} {
+		newPoller(e, time.Minute).poll(context.Background())
+	}
+
+	// Serving the lag must not search the servers again.
+	for _, conn := range []*mockLDAP{supplierConn, consumerConn} {
+		conn.searchFunc = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			t.Errorf("unexpected search of %s", req.BaseDN)
+			return &ldap.SearchResult{}, nil
+		}
+	}
+	c := newReplicationLagCollector([]lagTarget{
+		{name: "supplier1", exporter: supplier},
+		{name: "consumer1", exporter: consumer},
+	}, time.Minute)
+
+	got, ok := gatherValue(t, c, "ds_exporter_replication_lag_seconds", map[string]string{
+		"replica_id": "1",
+		"supplier":   "supplier1",
+		"consumer":   "consumer1",
+	})
+	if !ok || got != 0x40 {
+		t.Errorf("lag = %v (present %v), want 64", got, ok)
+	}
+
+	// RUVs older than the snapshot age are not compared.
+	supplier.ruvs.taken = time.Now().Add(-2 * time.Minute)
+	if _, ok := gatherValue(t, c, "ds_exporter_replication_lag_seconds", nil); ok {
+		t.Error("a stale supplier RUV should not yield a lag")
+	}
+}
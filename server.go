@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serverCollector exports the non-numeric identity and clock attributes of
+// the cn=monitor entry.
+type serverCollector struct {
+	infoDesc      *prometheus.Desc
+	startTimeDesc *prometheus.Desc
+	uptimeDesc    *prometheus.Desc
+	clockSkewDesc *prometheus.Desc
+	backendsDesc  *prometheus.Desc
+
+	// now is the exporter clock the server's currenttime is compared to.
+	now func() time.Time
+}
+
+func newServerCollector() *serverCollector {
+	return &serverCollector{
+		infoDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "server_info"),
+			"Version of the directory server", []string{"version"}, nil,
+		),
+		startTimeDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "start_time_seconds"),
+			"Unix time the directory server started", nil, nil,
+		),
+		uptimeDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "uptime_seconds"),
+			"Time since the directory server started, by the server clock", nil, nil,
+		),
+		clockSkewDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "clock_skew_seconds"),
+			"Server currenttime minus the exporter clock; positive when the server is ahead", nil, nil,
+		),
+		backendsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "nbackends"),
+			"Number of backends the directory server serves", nil, nil,
+		),
+		now: time.Now,
+	}
+}
+
+func (c *serverCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.infoDesc
+	ch <- c.startTimeDesc
+	ch <- c.uptimeDesc
+	ch <- c.clockSkewDesc
+	ch <- c.backendsDesc
+}
+
+func (c *serverCollector) collect(ctx context.Context, _ LDAPClient, _ time.Duration, monitor []*ldap.Entry, ch chan<- prometheus.Metric) error {
+	e := monitorEntry(monitor)
+	if e == nil {
+		return nil
+	}
+	now := c.now()
+
+	if v := e.GetEqualFoldAttributeValue("version"); v != "" {
+		ch <- prometheus.MustNewConstMetric(c.infoDesc, prometheus.GaugeValue, 1, v)
+	}
+	start, startErr := time.Parse(generalizedTimeLayout, e.GetEqualFoldAttributeValue("starttime"))
+	if startErr == nil {
+		ch <- prometheus.MustNewConstMetric(c.startTimeDesc, prometheus.GaugeValue, float64(start.Unix()))
+	}
+	if current, err := time.Parse(generalizedTimeLayout, e.GetEqualFoldAttributeValue("currenttime")); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.clockSkewDesc, prometheus.GaugeValue, current.Sub(now).Seconds())
+		if startErr == nil {
+			ch <- prometheus.MustNewConstMetric(c.uptimeDesc, prometheus.GaugeValue, current.Sub(start).Seconds())
+		}
+	}
+	if n, err := strconv.ParseFloat(e.GetEqualFoldAttributeValue("nbackends"), 64); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.backendsDesc, prometheus.GaugeValue, n)
+	}
+	return nil
+}
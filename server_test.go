@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestServerCollector(t *testing.T) {
+	sc := newServerCollector()
+	sc.now = func() time.Time { return time.Date(2024, 1, 31, 12, 0, 3, 0, time.UTC) }
+	c := collectorFunc{
+		c: sc,
+		monitor: []*ldap.Entry{{
+			DN: "cn=monitor",
+			Attributes: []*ldap.EntryAttribute{
+				{Name: "version", Values: []string{"389-Directory/2.4.5 B2024.017.0000"}},
+				{Name: "startTime", Values: []string{"20240130120000Z"}},
+				{Name: "currentTime", Values: []string{"20240131120000Z"}},
+				{Name: "nbackends", Values: []string{"2"}},
+			},
+		}},
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"ds_exporter_server_info", map[string]string{"version": "389-Directory/2.4.5 B2024.017.0000"}, 1},
+		{"ds_exporter_start_time_seconds", nil, 1706616000},
+		{"ds_exporter_uptime_seconds", nil, 86400},
+		{"ds_exporter_clock_skew_seconds", nil, -3},
+		{"ds_exporter_nbackends", nil, 2},
+	}
+	for _, tt := range tests {
+		if got, ok := gatherValue(t, c, tt.name, tt.labels); !ok || got != tt.want {
+			t.Errorf("%s = %v (present %v), want %v", tt.name, got, ok, tt.want)
+		}
+	}
+}
+
+func TestServerCollector_MissingAttributes(t *testing.T) {
+	c := collectorFunc{
+		c:       newServerCollector(),
+		monitor: []*ldap.Entry{{DN: "cn=monitor"}},
+	}
+	for _, name := range []string{"ds_exporter_server_info", "ds_exporter_start_time_seconds", "ds_exporter_clock_skew_seconds"} {
+		if _, ok := gatherValue(t, c, name, nil); ok {
+			t.Errorf("%s exported without its attribute", name)
+		}
+	}
+}
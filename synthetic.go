@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Steps of a synthetic transaction, in the order they run.
+const (
+	syntheticStepConnect = "connect"
+	syntheticStepBind    = "bind"
+	syntheticStepSearch  = "search"
+	syntheticStepCompare = "compare"
+)
+
+// errCompareFalse is returned by the compare step when the entry does not
+// hold the expected value.
+var errCompareFalse = errors.New("compare returned false")
+
+// syntheticConfig declares a transaction run on its own connection at every
+// scrape, the way an application would use the server.
+//
+//	synthetic:
+//	  - name: app_login
+//	    bind_dn: uid=probe,ou=people,dc=example,dc=com
+//	    bind_password_file: /etc/ds_exporter/probe-password
+//	    base: ou=people,dc=example,dc=com
+//	    filter: (uid=probe)
+//	    compare:
+//	      dn: uid=probe,ou=people,dc=example,dc=com
+//	      attribute: description
+//	      value: synthetic probe
+type syntheticConfig struct {
+	Name             string                  `yaml:"name"`
+	BindDN           string                  `yaml:"bind_dn"`
+	BindPasswordFile string                  `yaml:"bind_password_file"`
+	BindPasswordEnv  string                  `yaml:"bind_password_env"`
+	Base             string                  `yaml:"base"`
+	Scope            string                  `yaml:"scope"`
+	Filter           string                  `yaml:"filter"`
+	Timeout          time.Duration           `yaml:"timeout"`
+	Compare          *syntheticCompareConfig `yaml:"compare"`
+}
+
+// syntheticCompareConfig is the optional compare step. It succeeds when the
+// attribute of the entry holds the value.
+type syntheticCompareConfig struct {
+	DN        string `yaml:"dn"`
+	Attribute string `yaml:"attribute"`
+	Value     string `yaml:"value"`
+}
+
+// syntheticProbe is a validated syntheticConfig.
+type syntheticProbe struct {
+	name         string
+	bindDN       string
+	bindPassword string
+	base         string
+	scope        int
+	filter       string
+	timeout      time.Duration
+	compare      *syntheticCompareConfig
+}
+
+// syntheticProbes holds the transactions declared in --config.file; every
+// target runs them.
+var syntheticProbes []*syntheticProbe
+
+// buildSyntheticProbes validates the transaction declarations and reads
+// their passwords.
+func buildSyntheticProbes(cfgs []syntheticConfig) ([]*syntheticProbe, error) {
+	seen := make(map[string]bool)
+	var probes []*syntheticProbe
+	for i, sc := range cfgs {
+		id := fmt.Sprintf("synthetic probe #%d", i+1)
+		if sc.Name != "" {
+			id = fmt.Sprintf("synthetic probe %q", sc.Name)
+		}
+		if seen[sc.Name] {
+			return nil, fmt.Errorf("%s: duplicate name", id)
+		}
+		seen[sc.Name] = true
+		p, err := sc.build()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+		probes = append(probes, p)
+	}
+	return probes, nil
+}
+
+func (sc syntheticConfig) build() (*syntheticProbe, error) {
+	if sc.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if sc.Base == "" {
+		return nil, fmt.Errorf("base is required")
+	}
+	if _, err := ldap.ParseDN(sc.Base); err != nil {
+		return nil, fmt.Errorf("invalid base %q: %v", sc.Base, err)
+	}
+	p := &syntheticProbe{
+		name:    sc.Name,
+		bindDN:  sc.BindDN,
+		base:    sc.Base,
+		filter:  sc.Filter,
+		timeout: sc.Timeout,
+		compare: sc.Compare,
+	}
+	scope := sc.Scope
+	if scope == "" {
+		scope = "sub"
+	}
+	var ok bool
+	if p.scope, ok = queryScopes[scope]; !ok {
+		return nil, fmt.Errorf("invalid scope %q: must be base, one or sub", sc.Scope)
+	}
+	if p.filter == "" {
+		p.filter = "(objectclass=*)"
+	}
+	if _, err := ldap.CompileFilter(p.filter); err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v", p.filter, err)
+	}
+	if p.timeout < 0 {
+		return nil, fmt.Errorf("timeout must not be negative, got %v", p.timeout)
+	}
+	if p.bindDN != "" {
+		// The probe identity is not the exporter's, so its password is
+		// never taken from the default environment variable.
+		if sc.BindPasswordFile == "" && sc.BindPasswordEnv == "" {
+			return nil, fmt.Errorf("bind_dn set but neither bind_password_file nor bind_password_env given")
+		}
+		pw, err := readBindPassword(sc.BindPasswordFile, sc.BindPasswordEnv)
+		if err != nil {
+			return nil, err
+		}
+		if pw == "" {
+			return nil, fmt.Errorf("empty bind password for %s", p.bindDN)
+		}
+		p.bindPassword = pw
+	}
+	if c := p.compare; c != nil {
+		if c.DN == "" || c.Attribute == "" {
+			return nil, fmt.Errorf("compare needs dn and attribute")
+		}
+		if _, err := ldap.ParseDN(c.DN); err != nil {
+			return nil, fmt.Errorf("invalid compare dn %q: %v", c.DN, err)
+		}
+	}
+	return p, nil
+}
+
+// syntheticCollector runs the synthetic transactions against a target. Each
+// transaction dials its own connection so its bind never changes the
+// identity of the exporter's connection.
+type syntheticCollector struct {
+	probes []*syntheticProbe
+	dial   func(ctx context.Context) (LDAPClient, error)
+
+	successDesc *prometheus.Desc
+	duration    *prometheus.HistogramVec
+}
+
+func newSyntheticCollector(probes []*syntheticProbe, dial func(ctx context.Context) (LDAPClient, error)) *syntheticCollector {
+	return &syntheticCollector{
+		probes: probes,
+		dial:   dial,
+		successDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "synthetic_step_success"),
+			"Whether the step of the synthetic transaction succeeded at the last scrape (1) or not (0); steps after a failure are not run",
+			[]string{"probe", "step"}, nil,
+		),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "synthetic_step_duration_seconds",
+			Help:      "Time taken by the steps of the synthetic transactions",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"probe", "step"}),
+	}
+}
+
+func (c *syntheticCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.successDesc
+	c.duration.Describe(ch)
+}
+
+// collect runs every transaction. A failing transaction is reported by its
+// metrics rather than as a scrape error: the exporter reached the server
+// fine.
+func (c *syntheticCollector) collect(ctx context.Context, _ LDAPClient, timeout time.Duration, _ []*ldap.Entry, ch chan<- prometheus.Metric) error {
+	for _, p := range c.probes {
+		c.run(ctx, p, timeout, ch)
+	}
+	c.duration.Collect(ch)
+	return nil
+}
+
+// run executes the steps of p until one fails, each bounded by the probe's
+// timeout or else the module's.
+func (c *syntheticCollector) run(ctx context.Context, p *syntheticProbe, timeout time.Duration, ch chan<- prometheus.Metric) {
+	if p.timeout > 0 {
+		timeout = p.timeout
+	}
+	report := func(name string, start time.Time, err error) bool {
+		c.duration.WithLabelValues(p.name, name).Observe(time.Since(start).Seconds())
+		ok := 1.0
+		if err != nil {
+			log.Printf("Synthetic probe %s failed at %s: %v", p.name, name, err)
+			ok = 0
+		}
+		ch <- prometheus.MustNewConstMetric(c.successDesc, prometheus.GaugeValue, ok, p.name, name)
+		return err == nil
+	}
+
+	start := time.Now()
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	conn, err := c.dial(dialCtx)
+	cancel()
+	if !report(syntheticStepConnect, start, err) {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	step := func(name string, fn func() error) bool {
+		start := time.Now()
+		_, err := runLDAP(ctx, conn, timeout, func() (struct{}, error) {
+			return struct{}{}, fn()
+		})
+		return report(name, start, err)
+	}
+
+	if p.bindDN != "" && !step(syntheticStepBind, func() error {
+		return conn.Bind(p.bindDN, p.bindPassword)
+	}) {
+		return
+	}
+
+	if !step(syntheticStepSearch, func() error {
+		req := ldap.NewSearchRequest(
+			p.base,
+			p.scope, ldap.NeverDerefAliases, 0, 0, false,
+			p.filter,
+			[]string{"1.1"},
+			nil,
+		)
+		_, err := conn.Search(req)
+		return err
+	}) {
+		return
+	}
+
+	if p.compare != nil {
+		step(syntheticStepCompare, func() error {
+			ok, err := conn.Compare(p.compare.DN, p.compare.Attribute, p.compare.Value)
+			if err == nil && !ok {
+				err = errCompareFalse
+			}
+			return err
+		})
+	}
+}
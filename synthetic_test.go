@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// syntheticMock answers the steps of a synthetic transaction and records
+// what it was asked.
+type syntheticMock struct {
+	mockLDAP
+	bound, searched string
+	compared        []string
+	closed          bool
+}
+
+func newSyntheticMock(bindErr error, compareResult bool) *syntheticMock {
+	m := &syntheticMock{}
+	m.bindFunc = func(username, password string) error {
+		m.bound = username + ":" + password
+		return bindErr
+	}
+	m.searchFunc = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		m.searched = req.BaseDN + " " + req.Filter
+		return &ldap.SearchResult{Entries: []*ldap.Entry{{DN: "uid=probe,dc=example,dc=com"}}}, nil
+	}
+	m.compareFunc = func(dn, attribute, value string) (bool, error) {
+		m.compared = []string{dn, attribute, value}
+		return compareResult, nil
+	}
+	m.closeFunc = func() error {
+		m.closed = true
+		return nil
+	}
+	return m
+}
+
+func testSyntheticProbe(t *testing.T) *syntheticProbe {
+	t.Helper()
+	t.Setenv("PROBE_PASSWORD", "secret")
+	probes, err := buildSyntheticProbes([]syntheticConfig{{
+		Name:            "login",
+		BindDN:          "uid=probe,dc=example,dc=com",
+		BindPasswordEnv: "PROBE_PASSWORD",
+		Base:            "dc=example,dc=com",
+		Filter:          "(uid=probe)",
+		Compare:         &syntheticCompareConfig{DN: "uid=probe,dc=example,dc=com", Attribute: "description", Value: "probe"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return probes[0]
+}
+
+func TestSyntheticCollector(t *testing.T) {
+	conn := newSyntheticMock(nil, true)
+	c := newSyntheticCollector([]*syntheticProbe{testSyntheticProbe(t)}, func(context.Context) (LDAPClient, error) { return conn, nil })
+	f := collectorFunc{c: c}
+
+	for _, step := range []string{syntheticStepConnect, syntheticStepBind, syntheticStepSearch, syntheticStepCompare} {
+		labels := map[string]string{"probe": "login", "step": step}
+		if v, ok := gatherValue(t, f, "ds_exporter_synthetic_step_success", labels); !ok || v != 1 {
+			t.Errorf("%s success = %v (present %v), want 1", step, v, ok)
+		}
+	}
+	// Every gather above ran the transaction once more.
+	if count, _, ok := gatherHistogram(t, f, "ds_exporter_synthetic_step_duration_seconds", map[string]string{"probe": "login", "step": "search"}); !ok || count != 5 {
+		t.Errorf("search duration count = %d (present %v), want 5", count, ok)
+	}
+
+	if conn.bound != "uid=probe,dc=example,dc=com:secret" {
+		t.Errorf("bound as %q", conn.bound)
+	}
+	if conn.searched != "dc=example,dc=com (uid=probe)" {
+		t.Errorf("searched %q", conn.searched)
+	}
+	if strings.Join(conn.compared, "|") != "uid=probe,dc=example,dc=com|description|probe" {
+		t.Errorf("compared %v", conn.compared)
+	}
+	if !conn.closed {
+		t.Error("probe connection was not closed")
+	}
+}
+
+func TestSyntheticCollector_StopsAtFailedStep(t *testing.T) {
+	conn := newSyntheticMock(errors.New("invalid credentials"), true)
+	c := newSyntheticCollector([]*syntheticProbe{testSyntheticProbe(t)}, func(context.Context) (LDAPClient, error) { return conn, nil })
+	f := collectorFunc{c: c}
+
+	if v, ok := gatherValue(t, f, "ds_exporter_synthetic_step_success", map[string]string{"step": syntheticStepBind}); !ok || v != 0 {
+		t.Errorf("bind success = %v (present %v), want 0", v, ok)
+	}
+	if _, ok := gatherValue(t, f, "ds_exporter_synthetic_step_success", map[string]string{"step": syntheticStepSearch}); ok {
+		t.Error("search should not run after a failed bind")
+	}
+	if !conn.closed {
+		t.Error("probe connection was not closed")
+	}
+}
+
+func TestSyntheticCollector_CompareFalse(t *testing.T) {
+	conn := newSyntheticMock(nil, false)
+	c := newSyntheticCollector([]*syntheticProbe{testSyntheticProbe(t)}, func(context.Context) (LDAPClient, error) { return conn, nil })
+
+	if v, ok := gatherValue(t, collectorFunc{c: c}, "ds_exporter_synthetic_step_success", map[string]string{"step": syntheticStepCompare}); !ok || v != 0 {
+		t.Errorf("compare success = %v (present %v), want 0", v, ok)
+	}
+}
+
+func TestSyntheticCollector_DialFailure(t *testing.T) {
+	c := newSyntheticCollector([]*syntheticProbe{testSyntheticProbe(t)}, func(context.Context) (LDAPClient, error) {
+		return nil, errors.New("connection refused")
+	})
+	if v, ok := gatherValue(t, collectorFunc{c: c}, "ds_exporter_synthetic_step_success", map[string]string{"step": syntheticStepConnect}); !ok || v != 0 {
+		t.Errorf("connect success = %v (present %v), want 0", v, ok)
+	}
+}
+
+func TestExporter_SyntheticProbes(t *testing.T) {
+	probe := testSyntheticProbe(t)
+	old := syntheticProbes
+	syntheticProbes = []*syntheticProbe{probe}
+	defer func() { syntheticProbes = old }()
+
+	conn := newSyntheticMock(nil, true)
+	e := mockExporter(conn)
+
+	if v, ok := gatherValue(t, e, "ds_exporter_synthetic_step_success", map[string]string{"probe": "login", "step": syntheticStepCompare}); !ok || v != 1 {
+		t.Errorf("compare success = %v (present %v), want 1", v, ok)
+	}
+}
+
+func TestBuildSyntheticProbes_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  syntheticConfig
+		want string
+	}{
+		{"no name", syntheticConfig{Base: "dc=example"}, "synthetic probe #1: name is required"},
+		{"no base", syntheticConfig{Name: "p"}, "base is required"},
+		{"bad scope", syntheticConfig{Name: "p", Base: "dc=example", Scope: "children"}, "invalid scope"},
+		{"bad filter", syntheticConfig{Name: "p", Base: "dc=example", Filter: "uid=x"}, "invalid filter"},
+		{"no password", syntheticConfig{Name: "p", Base: "dc=example", BindDN: "uid=probe"}, "neither bind_password_file"},
+		{"incomplete compare", syntheticConfig{Name: "p", Base: "dc=example", Compare: &syntheticCompareConfig{DN: "uid=probe"}}, "compare needs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildSyntheticProbes([]syntheticConfig{tt.cfg})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// tailPollInterval is how often a tailer looks for new lines, rotation and
+// truncation once it has reached the end of the file.
+const tailPollInterval = time.Second
+
+// tailer follows a log file like tail -F: it starts at the end of the file,
+// reopens the path when the file is rotated away and starts over when the
+// file is truncated. Complete lines are passed to handle; a trailing partial
+// line waits until its newline is written.
+type tailer struct {
+	path   string
+	poll   time.Duration
+	handle func(line string)
+
+	f       *os.File
+	r       *bufio.Reader
+	info    os.FileInfo
+	offset  int64
+	partial string
+	missing bool
+}
+
+func newTailer(path string, handle func(line string)) *tailer {
+	return &tailer{path: path, poll: tailPollInterval, handle: handle}
+}
+
+// run follows the file until ctx is done.
+func (t *tailer) run(ctx context.Context) {
+	defer t.close()
+
+	first := true
+	for {
+		if t.f == nil {
+			// Only the file found at startup is skipped to its end; files
+			// appearing later are new and read from the start.
+			t.open(first)
+		}
+		first = false
+		if t.f != nil {
+			t.readLines()
+			t.checkRotation()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(t.poll):
+		}
+	}
+}
+
+func (t *tailer) open(atEnd bool) {
+	f, err := os.Open(t.path) // #nosec G304 -- path is operator supplied
+	if err != nil {
+		if !t.missing {
+			log.Printf("Cannot open log file %s: %v", t.path, err)
+			t.missing = true
+		}
+		return
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return
+	}
+	t.offset = 0
+	if atEnd {
+		if t.offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			_ = f.Close()
+			return
+		}
+	}
+	if t.missing {
+		log.Printf("Following log file %s", t.path)
+		t.missing = false
+	}
+	t.f, t.info, t.r, t.partial = f, info, bufio.NewReader(f), ""
+}
+
+func (t *tailer) close() {
+	if t.f != nil {
+		_ = t.f.Close()
+		t.f = nil
+	}
+}
+
+// readLines hands every complete line up to the end of the file to handle.
+func (t *tailer) readLines() {
+	for {
+		s, err := t.r.ReadString('\n')
+		t.offset += int64(len(s))
+		if err != nil {
+			t.partial += s
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Error reading log file %s: %v", t.path, err)
+				t.close()
+			}
+			return
+		}
+		line := strings.TrimRight(t.partial+s, "\r\n")
+		t.partial = ""
+		t.handle(line)
+	}
+}
+
+// checkRotation reopens the path when it names a different file than the
+// one being read, and rewinds when the file shrank below the read offset.
+func (t *tailer) checkRotation() {
+	if t.f == nil {
+		return
+	}
+	info, err := os.Stat(t.path)
+	switch {
+	case err != nil:
+		// Rotated away and not recreated yet: keep the old file until
+		// the new one appears.
+		return
+	case !os.SameFile(t.info, info):
+		// Pick up what was written to the old file since the last read.
+		t.readLines()
+		t.close()
+		t.open(false)
+	case info.Size() < t.offset:
+		if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+			t.close()
+			return
+		}
+		t.offset, t.partial = 0, ""
+		t.r.Reset(t.f)
+	}
+}
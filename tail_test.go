@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startTailer follows path and returns a channel of the lines read.
+func startTailer(t *testing.T, path string) <-chan string {
+	t.Helper()
+	lines := make(chan string, 100)
+	tl := newTailer(path, func(line string) { lines <- line })
+	tl.poll = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tl.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return lines
+}
+
+func appendFile(t *testing.T, path, s string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectLine(t *testing.T, lines <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-lines:
+		if got != want {
+			t.Errorf("line = %q, want %q", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %q", want)
+	}
+}
+
+func TestTailer_FollowsFromEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access")
+	appendFile(t, path, "old line\n")
+	lines := startTailer(t, path)
+	// Let the tailer open the file and seek to its end
+	time.Sleep(30 * time.Millisecond)
+
+	appendFile(t, path, "first\nsec")
+	expectLine(t, lines, "first")
+	appendFile(t, path, "ond\n")
+	expectLine(t, lines, "second")
+}
+
+func TestTailer_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access")
+	appendFile(t, path, "")
+	lines := startTailer(t, path)
+	time.Sleep(30 * time.Millisecond)
+
+	appendFile(t, path, "before rotation\n")
+	expectLine(t, lines, "before rotation")
+
+	if err := os.Rename(path, filepath.Join(dir, "access.20240131")); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "after rotation\n")
+	expectLine(t, lines, "after rotation")
+}
+
+func TestTailer_Truncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access")
+	appendFile(t, path, "")
+	lines := startTailer(t, path)
+	time.Sleep(30 * time.Millisecond)
+
+	appendFile(t, path, "a fairly long line before truncation\n")
+	expectLine(t, lines, "a fairly long line before truncation")
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	appendFile(t, path, "short\n")
+	expectLine(t, lines, "short")
+}
+
+func TestTailer_FileAppearsLater(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access")
+	lines := startTailer(t, path)
+	time.Sleep(30 * time.Millisecond)
+
+	appendFile(t, path, "created\n")
+	expectLine(t, lines, "created")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// TLS modes accepted by --ldap.tls.mode.
+const (
+	tlsModeNone     = "none"
+	tlsModeLDAPS    = "ldaps"
+	tlsModeStartTLS = "starttls"
+)
+
+// errTLS marks TLS handshake and negotiation failures so they are reported
+// apart from plain LDAP errors.
+var errTLS = errors.New("TLS handshake failed")
+
+// tlsSettings describes how the exporter secures its LDAP connection.
+type tlsSettings struct {
+	Mode       string `yaml:"mode"`
+	CAFile     string `yaml:"ca_file"`
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ServerName string `yaml:"server_name"`
+	MinVersion string `yaml:"min_version"`
+	// Check lists the listeners whose certificates are exported, as
+	// ldaps or starttls, each optionally followed by :port.
+	Check []string `yaml:"check"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// config validates the settings and builds the matching *tls.Config. It
+// returns nil when TLS is disabled and no other TLS setting is given; with
+// mode none the config still applies to ldaps:// targets and certificate
+// checks.
+func (s tlsSettings) config() (*tls.Config, error) {
+	switch s.Mode {
+	case "", tlsModeNone:
+		if s.CAFile == "" && s.CertFile == "" && s.KeyFile == "" && s.ServerName == "" && s.MinVersion == "" && len(s.Check) == 0 {
+			return nil, nil
+		}
+	case tlsModeLDAPS, tlsModeStartTLS:
+	default:
+		return nil, fmt.Errorf("unknown TLS mode %q: must be one of %s, %s, %s", s.Mode, tlsModeNone, tlsModeLDAPS, tlsModeStartTLS)
+	}
+
+	cfg := &tls.Config{
+		ServerName: s.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if s.MinVersion != "" {
+		v, ok := tlsVersions[s.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS min version %q: must be 1.0, 1.1, 1.2 or 1.3", s.MinVersion)
+		}
+		cfg.MinVersion = v
+	}
+
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile) // #nosec G304 -- path is operator supplied
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", s.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be given together")
+	}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// scheme returns the LDAP URL scheme for the configured mode. StartTLS
+// upgrades a plain ldap:// connection.
+func (s tlsSettings) scheme() string {
+	if s.Mode == tlsModeLDAPS {
+		return "ldaps"
+	}
+	return "ldap"
+}
+
+// dialLDAP connects to addr and negotiates TLS according to the module. The
+// connect and the TLS handshake are done separately so that handshake
+// failures can be reported as errTLS. ctx bounds both; the returned
+// connection times out each request after the module timeout.
+func dialLDAP(ctx context.Context, addr string, m *module) (LDAPClient, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := m.tlsConfig
+	if cfg == nil && u.Scheme == "ldaps" {
+		// An ldaps:// target of a module without TLS settings is verified
+		// against the system roots.
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if cfg != nil && cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = u.Hostname()
+	}
+	c, err := dialConn(ctx, u, m.TLS.Mode == tlsModeStartTLS, cfg, m.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// dialConn connects to u, handshaking with cfg for ldaps:// URLs or with
+// StartTLS when startTLS is set.
+func dialConn(ctx context.Context, u *url.URL, startTLS bool, cfg *tls.Config, timeout time.Duration) (*ldapClient, error) {
+	if timeout <= 0 {
+		timeout = ldap.DefaultTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var raw net.Conn
+	var err error
+	switch u.Scheme {
+	case "ldapi":
+		raw, err = dialer.DialContext(ctx, "unix", u.Path)
+	case "ldap", "ldaps":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), ldap.DefaultLdapPort)
+			if u.Scheme == "ldaps" {
+				host = net.JoinHostPort(u.Hostname(), ldap.DefaultLdapsPort)
+			}
+		}
+		raw, err = dialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("unknown scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "ldaps" {
+		tc := tls.Client(raw, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			_ = raw.Close()
+			return nil, fmt.Errorf("%w with %s: %v", errTLS, u.Host, err)
+		}
+		raw = tc
+	}
+	conn := ldap.NewConn(raw, u.Scheme == "ldaps")
+	conn.Start()
+	conn.SetTimeout(timeout)
+	c := &ldapClient{conn: conn}
+
+	if startTLS {
+		_, err := runLDAP(ctx, c, timeout, func() (struct{}, error) {
+			return struct{}{}, conn.StartTLS(cfg)
+		})
+		if err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("%w: StartTLS with %s: %v", errTLS, u.Host, err)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPKI is a throwaway CA plus a leaf certificate for localhost.
+type testPKI struct {
+	caFile   string
+	certFile string
+	keyFile  string
+	leaf     tls.Certificate
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := testPKI{
+		caFile:   filepath.Join(dir, "ca.pem"),
+		certFile: filepath.Join(dir, "cert.pem"),
+		keyFile:  filepath.Join(dir, "key.pem"),
+	}
+	writePEM(t, p.caFile, "CERTIFICATE", caDER)
+	writePEM(t, p.certFile, "CERTIFICATE", der)
+	writePEM(t, p.keyFile, "EC PRIVATE KEY", keyDER)
+
+	p.leaf, err = tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTLSListener accepts connections and completes a TLS handshake on each.
+func startTLSListener(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = c.(*tls.Conn).Handshake()
+				buf := make([]byte, 1)
+				_, _ = c.Read(buf)
+				_ = c.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestTLSSettingsConfig_None(t *testing.T) {
+	cfg, err := tlsSettings{Mode: tlsModeNone}.config()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg != nil {
+		t.Error("expected nil config when TLS is disabled")
+	}
+}
+
+func TestTLSSettingsConfig_Full(t *testing.T) {
+	p := newTestPKI(t)
+	cfg, err := tlsSettings{
+		Mode:       tlsModeLDAPS,
+		CAFile:     p.caFile,
+		CertFile:   p.certFile,
+		KeyFile:    p.keyFile,
+		ServerName: "ds.example.com",
+		MinVersion: "1.3",
+	}.config()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.RootCAs == nil {
+		t.Error("expected custom RootCAs")
+	}
+	if len(cfg.Certificates) != 1 {
+		t.Errorf("got %d client certificates, want 1", len(cfg.Certificates))
+	}
+	if cfg.ServerName != "ds.example.com" {
+		t.Errorf("ServerName = %q", cfg.ServerName)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 {
+		t.Errorf("MinVersion = %x, want TLS 1.3", cfg.MinVersion)
+	}
+}
+
+func TestTLSSettingsConfig_Invalid(t *testing.T) {
+	p := newTestPKI(t)
+	tests := []struct {
+		name string
+		s    tlsSettings
+	}{
+		{"unknown mode", tlsSettings{Mode: "ssl"}},
+		{"unknown version", tlsSettings{Mode: tlsModeLDAPS, MinVersion: "2.0"}},
+		{"missing CA file", tlsSettings{Mode: tlsModeLDAPS, CAFile: filepath.Join(t.TempDir(), "nope")}},
+		{"CA file without certs", tlsSettings{Mode: tlsModeLDAPS, CAFile: p.keyFile}},
+		{"cert without key", tlsSettings{Mode: tlsModeStartTLS, CertFile: p.certFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.s.config(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestDialLDAP_LDAPS(t *testing.T) {
+	p := newTestPKI(t)
+	addr := startTLSListener(t, p.leaf)
+
+	mod := &module{Timeout: 5 * time.Second, TLS: tlsSettings{Mode: tlsModeLDAPS, CAFile: p.caFile}}
+	if err := mod.validate(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := dialLDAP(context.Background(), "ldaps://"+addr, mod)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = c.Close()
+}
+
+func TestDialLDAP_LDAPSUntrusted(t *testing.T) {
+	p := newTestPKI(t)
+	addr := startTLSListener(t, p.leaf)
+
+	// Verify against a different CA
+	other := newTestPKI(t)
+	mod := &module{Timeout: 5 * time.Second, TLS: tlsSettings{Mode: tlsModeLDAPS, CAFile: other.caFile}}
+	if err := mod.validate(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := dialLDAP(context.Background(), "ldaps://"+addr, mod)
+	if !errors.Is(err, errTLS) {
+		t.Fatalf("err = %v, want errTLS", err)
+	}
+}
+
+func TestDialLDAP_LDAPSTargetWithoutTLSMode(t *testing.T) {
+	p := newTestPKI(t)
+	addr := startTLSListener(t, p.leaf)
+
+	// An ldaps:// URL handed to a module without a TLS mode still
+	// handshakes, verifying against the module CA.
+	mod := &module{Timeout: 5 * time.Second, TLS: tlsSettings{Mode: tlsModeNone, CAFile: p.caFile}}
+	if err := mod.validate(); err != nil {
+		t.Fatal(err)
+	}
+	target, err := mod.targetURL("ldaps://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := dialLDAP(context.Background(), target, mod)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = c.Close()
+
+	// Without any TLS settings the system roots are used, and the test CA
+	// is not among them.
+	plain := &module{Timeout: 5 * time.Second}
+	if err := plain.validate(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = dialLDAP(context.Background(), target, plain)
+	if !errors.Is(err, errTLS) || strings.Contains(err.Error(), "ServerName") {
+		t.Fatalf("err = %v, want a certificate verification error", err)
+	}
+}
+
+func TestDialLDAP_ConnectRefusedIsNotTLS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	mod := &module{Timeout: 5 * time.Second, TLS: tlsSettings{Mode: tlsModeLDAPS}}
+	if err := mod.validate(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = dialLDAP(context.Background(), "ldaps://"+addr, mod)
+	if err == nil {
+		t.Fatal("expected error dialing closed port")
+	}
+	if errors.Is(err, errTLS) {
+		t.Errorf("connect failure reported as TLS error: %v", err)
+	}
+}